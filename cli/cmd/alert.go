@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/timerange"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+	"github.com/spf13/cobra"
+)
+
+const incidentLatest = "latest"
+
+var (
+	defaultAlertPadding  = 30 * time.Minute
+	defaultAlertLookback = 24 * time.Hour
+
+	errNoIncidents        = fmt.Errorf("no incidents found")
+	errAmbiguousIncidents = fmt.Errorf("more than one incident found")
+	errInvalidIncident    = fmt.Errorf("invalid incident")
+)
+
+// validateAlertOptions ensures that the alert options don't conflict with the
+// time range options, since the time range is derived from the incident.
+func validateAlertOptions(cmd *cobra.Command) error {
+	alert, err := cmd.Flags().GetString("alert")
+	if err != nil {
+		return err
+	}
+
+	if alert == "" {
+		for _, name := range []string{"padding", "lookback", "incident"} {
+			if cmd.Flags().Changed(name) {
+				return fmt.Errorf(`flag %q requires "alert"`, name)
+			}
+		}
+		return nil
+	}
+
+	for _, name := range []string{"min-time", "max-time", "since", "around", "window"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf(`flags "alert" and %q are mutually exclusive`, name)
+		}
+	}
+
+	padding, err := cmd.Flags().GetDuration("padding")
+	if err != nil {
+		return err
+	}
+
+	if padding < 0 {
+		return fmt.Errorf("padding must not be negative: %s", padding)
+	}
+
+	lookback, err := cmd.Flags().GetDuration("lookback")
+	if err != nil {
+		return err
+	}
+
+	if lookback <= 0 {
+		return fmt.Errorf("lookback must be positive: %s", lookback)
+	}
+
+	incident, err := cmd.Flags().GetString("incident")
+	if err != nil {
+		return err
+	}
+
+	_, err = parseIncident(incident)
+	return err
+}
+
+// parseIncident returns the 1-based index of the incident selected by s, which
+// is either an index or "latest". Zero means that no incident is selected, and
+// -1 that the latest incident is.
+func parseIncident(s string) (int, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case incidentLatest:
+		return -1, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("%w: %q. expected a positive index or %q", errInvalidIncident, s, incidentLatest)
+	}
+
+	return i, nil
+}
+
+// resolveAlertTimeRange sets the min and max times to the time range of an
+// incident of the alert, padded on both sides. The incidents are read from the
+// ALERTS series of the Prometheus TSDB, within the lookback window. The
+// candidate incidents and the resolved time range are echoed to w.
+func resolveAlertTimeRange(ctx context.Context, config *config.Config, clientset *k8s.Clientset, w io.Writer) error {
+	var (
+		alert    = config.GetString("alert")
+		lookback = config.GetDuration("lookback")
+		now      = time.Now().UTC()
+	)
+
+	var (
+		execCmd = coreCommand(config,
+			"-alert", alert,
+			"-min-time", strconv.FormatInt(now.Add(-lookback).UnixNano(), 10),
+			"-max-time", strconv.FormatInt(now.UnixNano(), 10))
+		buf = &bytes.Buffer{}
+	)
+	err := clientset.Retry(ctx, func() error {
+		buf.Reset()
+		return execCore(ctx, config, clientset, execCmd, nil, buf)
+	})
+	if err != nil {
+		return err
+	}
+
+	var incidents []tsdb.Incident
+	if err := json.Unmarshal(buf.Bytes(), &incidents); err != nil {
+		return fmt.Errorf("can't parse incidents of alert %s: %w", alert, err)
+	}
+
+	incident, err := selectIncident(incidents, config.GetString("incident"), w)
+	if err != nil {
+		return fmt.Errorf("alert %s in the last %s: %w", alert, lookback, err)
+	}
+
+	padding := config.GetDuration("padding")
+	r := timerange.Range{
+		Min: incident.Start.Add(-padding),
+		Max: incident.End.Add(padding),
+	}
+	if r.Max.After(now) {
+		r.Max = now
+	}
+
+	if err := timerange.Validate(r, now); err != nil {
+		return err
+	}
+
+	config.Set("min-time", r.Min.Format(time.RFC3339Nano))
+	config.Set("max-time", r.Max.Format(time.RFC3339Nano))
+
+	_, err = fmt.Fprintf(w, "time range: %s\n", r)
+	return err
+}
+
+// selectIncident returns the incident selected by the incident option. If
+// there are more than one incident, they are listed to w, and one of them must
+// be selected.
+func selectIncident(incidents []tsdb.Incident, selected string, w io.Writer) (tsdb.Incident, error) {
+	if len(incidents) == 0 {
+		return tsdb.Incident{}, errNoIncidents
+	}
+
+	index, err := parseIncident(selected)
+	if err != nil {
+		return tsdb.Incident{}, err
+	}
+
+	if len(incidents) > 1 {
+		if err := printIncidents(incidents, w); err != nil {
+			return tsdb.Incident{}, err
+		}
+	}
+
+	switch {
+	case index == -1:
+		return incidents[len(incidents)-1], nil
+	case index == 0 && len(incidents) == 1:
+		return incidents[0], nil
+	case index == 0:
+		return tsdb.Incident{}, fmt.Errorf(`%w: select one with --incident INDEX or --incident %s`, errAmbiguousIncidents, incidentLatest)
+	case index > len(incidents):
+		return tsdb.Incident{}, fmt.Errorf("%w: %d. found %d incidents", errInvalidIncident, index, len(incidents))
+	default:
+		return incidents[index-1], nil
+	}
+}
+
+func printIncidents(incidents []tsdb.Incident, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, "INCIDENT\t| START (UTC)\t| END (UTC)\t| DURATION\t| SERIES")
+	for i, incident := range incidents {
+		fmt.Fprintf(tw, "%d\t| %s\t| %s\t| %s\t| %d\n",
+			i+1,
+			incident.Start.Format(timeFormat),
+			incident.End.Format(timeFormat),
+			incident.End.Sub(incident.Start).Round(time.Second),
+			incident.NumSeries)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+// backupPod dumps the entire TSDB of the Prometheus pod into a local
+// pre-restore-<pod>-<timestamp>.tar.gz file in dir, before it is overwritten
+// by restore. The backup can be put back with 'restore --undo'. It returns the
+// path of the backup file.
+func backupPod(ctx context.Context, config *config.Config, clientset *k8s.Clientset, dir string) (string, error) {
+	filename := filepath.Join(dir, backupFilename(config.GetString("pod"), time.Now()))
+	backupFile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("can't create backup file: %w", err)
+	}
+
+	if err := dumpAll(ctx, config, clientset, backupFile); err != nil {
+		_ = backupFile.Close()
+		_ = os.Remove(filename)
+		return "", fmt.Errorf("failed to back up pod: %w", err)
+	}
+
+	if err := backupFile.Close(); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// backupFilename returns the name of the backup file of pod, taken at now, in
+// UTC.
+func backupFilename(pod string, now time.Time) string {
+	return fmt.Sprintf("pre-restore-%s-%s.tar.gz", pod, now.UTC().Format(backupTimeFormat))
+}
+
+// dumpAll reuses the dump path to write all the data of the Prometheus pod to
+// backupFile. The whole TSDB is dumped, regardless of the clock of the
+// Prometheus container. The head chunk files are left as they are.
+func dumpAll(ctx context.Context, config *config.Config, clientset *k8s.Clientset, backupFile *os.File) error {
+	if err := uploadCore(ctx, config, clientset); err != nil {
+		return fmt.Errorf("failed to upload promdump: %w", err)
+	}
+	defer cleanupCore(ctx, config, clientset)
+
+	// -all overrides the min and max times
+	execCmd := append(dumpCommand(config, time.Unix(0, 0), time.Unix(0, 0), tsdb.RepairNone), "-all")
+	return execDump(ctx, config, clientset, execCmd, backupFile)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+)
+
+func TestBackupFilename(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		pod      string
+		now      time.Time
+		expected string
+	}{
+		{
+			name:     "utc",
+			pod:      "prometheus-0",
+			now:      time.Date(2021, 4, 18, 20, 35, 21, 0, time.UTC),
+			expected: "pre-restore-prometheus-0-20210418-203521.tar.gz",
+		},
+		{
+			name:     "local time",
+			pod:      "prometheus-0",
+			now:      time.Date(2021, 4, 18, 22, 35, 21, 0, time.FixedZone("CEST", 2*60*60)),
+			expected: "pre-restore-prometheus-0-20210418-203521.tar.gz",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := backupFilename(tc.pod, tc.now); actual != tc.expected {
+				t.Errorf("mismatch filename. expected: %s, actual: %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBackupPodFailure(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "promdump-backup-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// the API server rejects all requests, so the promdump binary can't be
+	// uploaded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	testConfig := &config.Config{Viper: viper.New()}
+	testConfig.Set("pod", "prometheus-0")
+	testConfig.Set("request-timeout", "5s")
+
+	clientset, err := k8s.NewClientset(testConfig, &rest.Config{Host: srv.URL}, log.New("debug", io.Discard))
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if _, err := backupPod(context.Background(), testConfig, clientset, tempDir); err == nil {
+		t.Fatal("expected error")
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("expected the backup file to be removed, actual: %s", entries[0].Name())
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/timerange"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+func initConfigCmd(rootCmd *cobra.Command) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Shows the promdump config file.",
+		Long: `Shows the promdump config file.
+
+The config file defines named profiles, with the defaults of the context,
+namespace, pod selector, container, data directory, time window and redaction
+policy. Flags take precedence over the PROMDUMP_* environment variables, which
+take precedence over the selected profile.`,
+		SilenceErrors: true, // let main() handles errors
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// the config file is read locally, without a cluster
+			return initConfig(cmd)
+		},
+	}
+
+	viewCmd := &cobra.Command{
+		Use:   "view [--minify]",
+		Short: "Shows the profiles of the promdump config file.",
+		Example: `# show all the profiles
+kubectl promdump config view
+
+# show the prod-eu profile
+kubectl promdump config view --minify --profile prod-eu`,
+		SilenceErrors: true, // let main() handles errors
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigView(cmd, os.Stdout)
+		},
+	}
+	viewCmd.Flags().Bool("minify", false, "show only the selected profile")
+
+	configCmd.AddCommand(viewCmd)
+	rootCmd.AddCommand(configCmd)
+	return configCmd
+}
+
+func runConfigView(cmd *cobra.Command, w io.Writer) error {
+	path, file, err := loadConfigFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	minify, err := cmd.Flags().GetBool("minify")
+	if err != nil {
+		return err
+	}
+
+	if minify {
+		name, profile, err := file.Profile(config.Lookup(cmd.Flags(), "profile"))
+		if err != nil {
+			return err
+		}
+
+		file = &config.File{CurrentProfile: name}
+		if profile != nil {
+			file.Profiles = map[string]*config.Profile{name: profile}
+		}
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "# %s\n", path); err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// loadConfigFile reads the config file of the config flag, or the default one.
+func loadConfigFile(cmd *cobra.Command) (string, *config.File, error) {
+	path := config.Lookup(cmd.Flags(), "config")
+	if path == "" {
+		var err error
+		if path, err = config.DefaultPath(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	file, err := config.Load(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, file, nil
+}
+
+// applyProfile sets the profile flags of cmd which aren't set explicitly, to
+// the values of the PROMDUMP_* environment variables, or of the selected
+// profile.
+func applyProfile(cmd *cobra.Command) error {
+	path, file, err := loadConfigFile(cmd)
+	if err != nil {
+		return err
+	}
+
+	name, profile, err := file.Profile(config.Lookup(cmd.Flags(), "profile"))
+	if err != nil {
+		return err
+	}
+
+	if err := config.Apply(cmd.Flags(), profile); err != nil {
+		return err
+	}
+
+	window := config.TimeWindow(profile)
+	if window == "" {
+		return nil
+	}
+
+	// the time window only applies to the commands with a time range, which
+	// isn't set explicitly
+	for _, name := range []string{"since", "min-time", "max-time", "around", "alert"} {
+		if flag := cmd.Flags().Lookup(name); flag == nil || flag.Changed {
+			return nil
+		}
+	}
+
+	if _, err := timerange.ParseDuration(window); err != nil {
+		if _, ok := os.LookupEnv(config.TimeWindowKey); ok {
+			return fmt.Errorf("invalid value of %s: %w", config.TimeWindowKey, err)
+		}
+		return fmt.Errorf("invalid time window of profile %s in %s: %w", name, path, err)
+	}
+
+	return cmd.Flags().Set("since", window)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/download"
+	"github.com/ihcsim/promdump/pkg/k8s"
+)
+
+const (
+	// coreSourceEmbedded uses the promdump binary embedded in the CLI. The
+	// builds which aren't embedded are downloaded.
+	coreSourceEmbedded = "embedded"
+
+	// coreSourceDownload downloads the promdump binary of the CLI version.
+	coreSourceDownload = "download"
+
+	// coreSourceFilePrefix prefixes the path of a local gzipped TAR file of
+	// the promdump binary.
+	coreSourceFilePrefix = "file:"
+
+	// defaultCoreBaseURL is where the promdump binaries of all the releases
+	// are published.
+	defaultCoreBaseURL = "https://github.com/ihcsim/promdump/releases/download"
+
+	// coreDownloadTimeout is how long to wait for a promdump binary to be
+	// downloaded.
+	coreDownloadTimeout = 5 * time.Minute
+
+	defaultCoreDownloadRetries   = 3
+	defaultCoreDownloadMaxSize   = 256 << 20
+	coreDownloadRetryInterval    = time.Second
+	coreDownloadRetryMaxInterval = 30 * time.Second
+)
+
+var (
+	errUnsupportedCoreSource = fmt.Errorf("unsupported core source")
+	errNoCorePublicKey       = fmt.Errorf("no public key is compiled into the CLI to verify the downloaded promdump binary")
+)
+
+// validateCoreSource returns an error if source isn't a supported source of
+// the promdump binary.
+func validateCoreSource(source string) error {
+	switch {
+	case source == coreSourceEmbedded, source == coreSourceDownload:
+		return nil
+	case strings.HasPrefix(source, coreSourceFilePrefix) && len(source) > len(coreSourceFilePrefix):
+		return nil
+	default:
+		return fmt.Errorf("%w: %q. must be one of %s, %s or %s<path>", errUnsupportedCoreSource, source, coreSourceEmbedded, coreSourceDownload, coreSourceFilePrefix)
+	}
+}
+
+// coreArchive returns the gzipped TAR file of the promdump binary, from the
+// source of the core-source option. Unless it's a local file, the binary is
+// built for the platform of the node of the targeted pod.
+func coreArchive(ctx context.Context, config *config.Config, clientset *k8s.Clientset) ([]byte, error) {
+	source := config.GetString("core-source")
+	if strings.HasPrefix(source, coreSourceFilePrefix) {
+		return os.ReadFile(strings.TrimPrefix(source, coreSourceFilePrefix))
+	}
+
+	container := config.GetString("container")
+	if ephemeral := config.GetString("ephemeral-container"); ephemeral != "" {
+		container = ephemeral
+	}
+
+	goos, goarch, err := clientset.NodePlatform(ctx, container)
+	if err != nil {
+		return nil, fmt.Errorf("can't detect node platform: %w", err)
+	}
+
+	if err := k8s.ValidatePlatform(goos, goarch); err != nil {
+		return nil, err
+	}
+
+	if source != coreSourceDownload {
+		name := fmt.Sprintf("promdump-%s-%s.tar.gz", goos, goarch)
+		if data, err := coreArchives.ReadFile(name); err == nil {
+			return data, nil
+		}
+
+		_ = level.Info(logger).Log("message", "promdump binary isn't embedded",
+			"os", goos,
+			"arch", goarch,
+			"version", Version)
+	}
+
+	return downloadCore(ctx, config, goos, goarch)
+}
+
+// downloadCore downloads the promdump binary of the CLI version, built for
+// the goos/goarch platform, to the cache directory. Its signature is verified
+// with the public key compiled into the CLI. The download is cancelled when ctx
+// is done.
+func downloadCore(ctx context.Context, config *config.Config, goos, goarch string) ([]byte, error) {
+	cacheDir, err := coreCacheDir(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+
+	d, err := download.New(cacheDir, coreDownloadTimeout, logger).
+		WithRetry(config.GetInt("core-download-retries"), coreDownloadRetryInterval, coreDownloadRetryMaxInterval).
+		WithMaxSize(int64(config.GetInt("core-download-max-size"))).
+		WithTransport(config.GetString("core-download-proxy"), config.GetString("core-download-ca-file"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case CorePublicKey == "" && !config.GetBool("insecure-skip-signature-verification"):
+		return nil, fmt.Errorf("%w. use an embedded or local promdump binary, or --insecure-skip-signature-verification", errNoCorePublicKey)
+	case CorePublicKey == "":
+		_ = level.Warn(logger).Log("message", "skipping the signature verification of the downloaded promdump binary")
+	default:
+		publicKey, err := download.ParsePublicKey(CorePublicKey)
+		if err != nil {
+			return nil, err
+		}
+		d = d.WithPublicKey(publicKey)
+	}
+
+	if reporter := newProgress(config, "download"); reporter != nil {
+		d = d.WithProgress(reporter.Set)
+		defer reporter.Done()
+	}
+
+	reader, err := d.GetCore(ctx, config.GetString("core-base-url"), Version, goos, goarch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download promdump %s for %s/%s: %w", Version, goos, goarch, err)
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// coreCacheDir returns the directory where the downloaded promdump binaries
+// are cached. It defaults to the promdump directory of the user cache
+// directory.
+func coreCacheDir(config *config.Config) (string, error) {
+	if dir := config.GetString("core-cache-dir"); dir != "" {
+		return dir, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("can't determine cache directory: %w", err)
+	}
+
+	return filepath.Join(cacheDir, "promdump"), nil
+}
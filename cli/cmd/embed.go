@@ -1,6 +1,15 @@
+//go:build !slim
+
 package main
 
-import _ "embed"
+import "embed"
+
+// defaultCoreSource is the default source of the promdump binary.
+const defaultCoreSource = coreSourceEmbedded
 
-//go:embed promdump.tar.gz
-var promdumpBin []byte
+// coreArchives are the gzipped TAR files of the promdump binary, built for
+// the linux architectures listed in the EMBED_ARCHS variable of the Makefile.
+// They are named promdump-linux-<arch>.tar.gz.
+//
+//go:embed promdump-linux-*.tar.gz
+var coreArchives embed.FS
@@ -0,0 +1,12 @@
+//go:build slim
+
+package main
+
+import "embed"
+
+// defaultCoreSource is the default source of the promdump binary. The slim
+// build doesn't embed it, so it's downloaded.
+const defaultCoreSource = coreSourceDownload
+
+// coreArchives is empty in the slim build.
+var coreArchives embed.FS
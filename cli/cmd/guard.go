@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+)
+
+const (
+	envDenyContexts   = "PROMDUMP_DENY_CONTEXTS"
+	envDenyNamespaces = "PROMDUMP_DENY_NAMESPACES"
+)
+
+var (
+	errDenied  = fmt.Errorf("denied by deny-list")
+	errAborted = fmt.Errorf("aborted by user")
+)
+
+// checkGuardrails refuses to overwrite the data of a Prometheus pod whose
+// context or namespace is in the deny-lists, or which is annotated with
+// promdump.io/protect=true.
+func checkGuardrails(config *config.Config, clientset *k8s.Clientset) error {
+	var (
+		context = config.GetString("context")
+		ns      = config.GetString("namespace")
+	)
+
+	denyContexts := append(config.GetStringSlice("deny-context"), splitEnv(envDenyContexts)...)
+	if pattern, ok := matchAny(denyContexts, context); ok {
+		return fmt.Errorf("%w: context %q matches %q", errDenied, context, pattern)
+	}
+
+	denyNamespaces := append(config.GetStringSlice("deny-namespace"), splitEnv(envDenyNamespaces)...)
+	if pattern, ok := matchAny(denyNamespaces, ns); ok {
+		return fmt.Errorf("%w: namespace %q matches %q", errDenied, ns, pattern)
+	}
+
+	return clientset.CanOverwrite()
+}
+
+// confirmRestore shows the targeted pod and the amount of data to be deleted,
+// and waits for the user's confirmation on r.
+func confirmRestore(ctx context.Context, config *config.Config, clientset *k8s.Clientset, r io.Reader, w io.Writer) error {
+	if config.GetBool("yes") {
+		return nil
+	}
+
+	size, err := dataDirSize(ctx, config, clientset)
+	if err != nil {
+		return fmt.Errorf("can't determine size of data directory: %w", err)
+	}
+
+	return promptRestore(config, size, r, w)
+}
+
+// promptRestore asks the user on w to confirm the deletion of size bytes of
+// data, and reads the answer from r. Only y and yes confirm it.
+func promptRestore(config *config.Config, size int64, r io.Reader, w io.Writer) error {
+	fmt.Fprintf(w, `The data of the following Prometheus will be deleted:
+  Context:   %s
+  Namespace: %s
+  Pod:       %s
+  Container: %s
+  Data dir:  %s (%d bytes)
+Continue? [y/N] `,
+		config.GetString("context"),
+		config.GetString("namespace"),
+		config.GetString("pod"),
+		config.GetString("container"),
+		config.GetString("data-dir"),
+		size)
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errAborted
+	}
+}
+
+// dataDirSize returns the approximate size of the data directory, in bytes.
+func dataDirSize(ctx context.Context, config *config.Config, clientset *k8s.Clientset) (int64, error) {
+	du := func(dataDir string) []string {
+		return []string{"du", "-sk", dataDir}
+	}
+
+	buf := &bytes.Buffer{}
+	err := clientset.Retry(ctx, func() error {
+		buf.Reset()
+		return execDataDir(ctx, config, clientset, du, nil, buf)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(buf.String())
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected output: %q", buf.String())
+	}
+
+	kilobytes, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return kilobytes * 1024, nil
+}
+
+func matchAny(patterns []string, s string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, s); err == nil && matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func splitEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
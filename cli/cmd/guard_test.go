@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestCheckGuardrails(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		context         string
+		namespace       string
+		denyContext     []string
+		denyNamespace   []string
+		envContexts     string
+		envNamespaces   string
+		protected       bool
+		expectDenied    bool
+		expectProtected bool
+	}{
+		{
+			name:      "allowed",
+			context:   "kind-dev",
+			namespace: "monitoring",
+		},
+		{
+			name:         "context glob",
+			context:      "gke_acme_prod-eu",
+			namespace:    "monitoring",
+			denyContext:  []string{"*staging*", "*prod*"},
+			expectDenied: true,
+		},
+		{
+			name:        "context glob mismatch",
+			context:     "kind-dev",
+			namespace:   "monitoring",
+			denyContext: []string{"*prod*"},
+		},
+		{
+			name:          "namespace",
+			context:       "kind-dev",
+			namespace:     "kube-system",
+			denyNamespace: []string{"kube-*"},
+			expectDenied:  true,
+		},
+		{
+			name:         "context from env",
+			context:      "gke_acme_prod-eu",
+			namespace:    "monitoring",
+			denyContext:  []string{"*staging*"},
+			envContexts:  "kind-*, *prod*",
+			expectDenied: true,
+		},
+		{
+			name:          "namespace from env",
+			context:       "kind-dev",
+			namespace:     "monitoring",
+			denyNamespace: []string{"kube-*"},
+			envNamespaces: "default,monitoring",
+			expectDenied:  true,
+		},
+		{
+			name:            "protected pod",
+			context:         "kind-dev",
+			namespace:       "monitoring",
+			protected:       true,
+			expectProtected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envDenyContexts, tc.envContexts)
+			t.Setenv(envDenyNamespaces, tc.envNamespaces)
+
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				pod := corev1.Pod{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+					ObjectMeta: metav1.ObjectMeta{Name: "prometheus-0", Namespace: tc.namespace},
+				}
+				if tc.protected {
+					pod.Annotations = map[string]string{k8s.ProtectAnnotation: "true"}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(pod); err != nil {
+					t.Error("unexpected error: ", err)
+				}
+			}))
+			defer srv.Close()
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("context", tc.context)
+			testConfig.Set("namespace", tc.namespace)
+			testConfig.Set("pod", "prometheus-0")
+			testConfig.Set("request-timeout", "5s")
+			testConfig.Set("deny-context", tc.denyContext)
+			testConfig.Set("deny-namespace", tc.denyNamespace)
+
+			clientset, err := k8s.NewClientset(testConfig, &rest.Config{Host: srv.URL}, log.New("debug", io.Discard))
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			err = checkGuardrails(testConfig, clientset)
+			switch {
+			case tc.expectDenied:
+				if !errors.Is(err, errDenied) {
+					t.Errorf("expected error: %v, actual: %v", errDenied, err)
+				}
+
+				// the deny-lists are checked before the pod is read
+				if requests != 0 {
+					t.Errorf("expected no requests, actual: %d", requests)
+				}
+			case tc.expectProtected:
+				if err == nil || !strings.Contains(err.Error(), k8s.ProtectAnnotation) {
+					t.Errorf("expected protected pod error, actual: %v", err)
+				}
+			case err != nil:
+				t.Fatal("unexpected error: ", err)
+			}
+		})
+	}
+}
+
+func TestConfirmRestore(t *testing.T) {
+	t.Run("yes flag", func(t *testing.T) {
+		testConfig := &config.Config{Viper: viper.New()}
+		testConfig.Set("yes", true)
+
+		// the data directory isn't read, and nothing is asked
+		var w bytes.Buffer
+		if err := confirmRestore(context.Background(), testConfig, nil, strings.NewReader(""), &w); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if w.Len() != 0 {
+			t.Errorf("expected no prompt, actual: %q", w.String())
+		}
+	})
+
+	var testCases = []struct {
+		name     string
+		answer   string
+		expected error
+	}{
+		{name: "y", answer: "y\n"},
+		{name: "yes", answer: "yes\n"},
+		{name: "upper case", answer: " YES \n"},
+		{name: "no newline", answer: "y"},
+		{name: "n", answer: "n\n", expected: errAborted},
+		{name: "empty", answer: "\n", expected: errAborted},
+		{name: "eof", answer: "", expected: errAborted},
+		{name: "other", answer: "sure\n", expected: errAborted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("context", "kind-dev")
+			testConfig.Set("namespace", "monitoring")
+			testConfig.Set("pod", "prometheus-0")
+
+			var w bytes.Buffer
+			if err := promptRestore(testConfig, 2048, strings.NewReader(tc.answer), &w); !errors.Is(err, tc.expected) {
+				t.Errorf("mismatch error. expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if prompt := w.String(); !strings.Contains(prompt, "prometheus-0") || !strings.HasSuffix(prompt, "Continue? [y/N] ") {
+				t.Errorf("mismatch prompt: %q", prompt)
+			}
+		})
+	}
+}
+
+func TestSplitEnv(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{name: "unset"},
+		{name: "single", value: "*prod*", expected: []string{"*prod*"}},
+		{name: "multiple", value: "*prod*,kube-*", expected: []string{"*prod*", "kube-*"}},
+		{name: "spaces", value: " *prod* , kube-* ", expected: []string{"*prod*", "kube-*"}},
+		{name: "empty values", value: ",*prod*,,", expected: []string{"*prod*"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envDenyContexts, tc.value)
+
+			if actual := splitEnv(envDenyContexts); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("mismatch values. expected: %q, actual: %q", tc.expected, actual)
+			}
+		})
+	}
+}
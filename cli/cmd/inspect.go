@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/archive"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+	"github.com/spf13/cobra"
+)
+
+func initInspectCmd(rootCmd *cobra.Command) (*cobra.Command, error) {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect -t DUMP_FILE",
+		Short: "Shows the content of a data dump file.",
+		Example: `# show the metadata of the head block, WAL and persistent blocks found in the
+# dump.tar.gz file.
+kubectl promdump inspect -t dump.tar.gz`,
+		SilenceErrors: true, // let main() handles errors
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// the dump file is inspected locally, without a cluster
+			return initConfig(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInspect(appConfig, os.Stdout)
+		},
+	}
+
+	inspectCmd.Flags().StringP("dump-file", "t", "", "path to the sample dump TAR file")
+	if err := inspectCmd.MarkFlagRequired("dump-file"); err != nil {
+		return nil, err
+	}
+
+	rootCmd.AddCommand(inspectCmd)
+	return inspectCmd, nil
+}
+
+func runInspect(config *config.Config, w io.Writer) error {
+	filename := config.GetString("dump-file")
+	dumpFile, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("can't open dump file: %w", err)
+	}
+	defer dumpFile.Close()
+
+	tempDir, err := os.MkdirTemp("", "promdump-inspect")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(dumpFile, tempDir); err != nil {
+		return fmt.Errorf("can't extract data dump: %w", err)
+	}
+
+	db, err := tsdb.New(tempDir, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Dump file:\t| %s\n\n", filename)
+
+	headChunks, outOfSequence, err := db.HeadChunks()
+	if err != nil {
+		return err
+	}
+
+	// the head block can't be loaded with out-of-sequence head chunk files.
+	// since this is a temporary copy of the dump, it's safe to remove them.
+	for _, file := range outOfSequence {
+		if err := os.Remove(file.Path); err != nil {
+			return err
+		}
+	}
+
+	headMeta, blockMeta, err := db.Meta()
+	if err != nil {
+		return fmt.Errorf("can't read tsdb metadata: %w", err)
+	}
+
+	printHeadMeta(tw, headMeta)
+
+	if err := printWALMeta(tw, db, headChunks, outOfSequence); err != nil {
+		return err
+	}
+
+	if err := printBlocksMeta(tw, db, blockMeta); err != nil {
+		return err
+	}
+
+	return tw.Flush()
+}
+
+func printHeadMeta(w io.Writer, headMeta *tsdb.HeadMeta) {
+	fmt.Fprintln(w, "Head Block Metadata")
+	fmt.Fprintln(w, "------------------------")
+	if headMeta.NumSeries == 0 || (headMeta.MinTime.IsZero() && headMeta.MaxTime.IsZero()) {
+		fmt.Fprintf(w, "%s\n\n", "No head block found")
+		return
+	}
+
+	fmt.Fprintf(w, "Minimum time (UTC):\t| %s\n", formatTime(headMeta.MinTime))
+	fmt.Fprintf(w, "Maximum time (UTC):\t| %s\n", formatTime(headMeta.MaxTime))
+	fmt.Fprintf(w, "Number of samples\t| %d\n", headMeta.NumSamples)
+	fmt.Fprintf(w, "Number of series\t| %d\n\n", headMeta.NumSeries)
+}
+
+func printWALMeta(w io.Writer, db *tsdb.Tsdb, headChunks, outOfSequence []tsdb.HeadChunkFile) error {
+	first, last, err := db.WALSegments()
+	if err != nil {
+		return err
+	}
+
+	checkpoints, err := db.Checkpoints()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "WAL And Head Chunks Metadata")
+	fmt.Fprintln(w, "----------------------------")
+
+	segments := "none"
+	if first >= 0 {
+		segments = fmt.Sprintf("%08d - %08d", first, last)
+	}
+	fmt.Fprintf(w, "WAL segments\t| %s\n", segments)
+	fmt.Fprintf(w, "WAL checkpoints\t| %s\n", joinOrNone(checkpoints))
+
+	var names []string
+	for _, file := range headChunks {
+		names = append(names, filepath.Base(file.Path))
+	}
+	fmt.Fprintf(w, "Head chunk files\t| %s\n", joinOrNone(names))
+
+	names = nil
+	var size int64
+	for _, file := range outOfSequence {
+		names = append(names, filepath.Base(file.Path))
+		size += file.Size
+	}
+	fmt.Fprintf(w, "Out-of-sequence head chunk files\t| %s\n", joinOrNone(names))
+	if len(outOfSequence) > 0 {
+		// these files are excluded from the head block metadata
+		fmt.Fprintf(w, "Out-of-sequence head chunk size\t| %d\n", size)
+	}
+	fmt.Fprintln(w)
+
+	return nil
+}
+
+func printBlocksMeta(w io.Writer, db *tsdb.Tsdb, blockMeta *tsdb.BlockMeta) error {
+	fmt.Fprintln(w, "Persistent Blocks Metadata")
+	fmt.Fprintln(w, "----------------------------")
+	if blockMeta.MinTime.IsZero() && blockMeta.MaxTime.IsZero() {
+		fmt.Fprintln(w, "No persistent blocks found")
+		return nil
+	}
+
+	fmt.Fprintf(w, "Minimum time (UTC):\t| %s\n", formatTime(blockMeta.MinTime))
+	fmt.Fprintf(w, "Maximum time (UTC):\t| %s\n", formatTime(blockMeta.MaxTime))
+	fmt.Fprintf(w, "Total number of blocks\t| %d\n", blockMeta.BlockCount)
+	fmt.Fprintf(w, "Total number of samples\t| %d\n", blockMeta.NumSamples)
+	fmt.Fprintf(w, "Total number of series\t| %d\n", blockMeta.NumSeries)
+	fmt.Fprintf(w, "Total size\t| %d\n\n", blockMeta.Size)
+
+	blocks, err := db.Blocks(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ULID\tMINIMUM TIME (UTC)\tMAXIMUM TIME (UTC)\tSAMPLES\tSERIES\tSIZE")
+	for _, block := range blocks {
+		meta := block.Meta()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n",
+			meta.ULID,
+			formatTime(time.Unix(0, meta.MinTime*int64(time.Millisecond))),
+			formatTime(time.Unix(0, meta.MaxTime*int64(time.Millisecond))),
+			meta.Stats.NumSamples,
+			meta.Stats.NumSeries,
+			block.Size())
+	}
+
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func joinOrNone(s []string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+
+	return strings.Join(s, ", ")
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // for --tz on systems without time zone database
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
@@ -14,11 +18,28 @@ func main() {
 	}
 
 	_ = initMetaCmd(rootCmd)
+	_ = initConfigCmd(rootCmd)
 	if _, err := initRestoreCmd(rootCmd); err != nil {
 		exitWithErr(err)
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	if _, err := initInspectCmd(rootCmd); err != nil {
+		exitWithErr(err)
+	}
+
+	if _, err := initRepairCmd(rootCmd); err != nil {
+		exitWithErr(err)
+	}
+
+	// the remote promdump process is stopped and removed on the first
+	// interrupt. a second interrupt terminates promdump immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		exitWithErr(err)
 	}
 }
@@ -89,6 +89,10 @@ func testArgs(f *pflag.Flag, t *testing.T) []string {
 		return args
 	case "string":
 		args = append(args, fmt.Sprintf("test-%s", f.Name))
+	case "duration":
+		args = append(args, "1m30s")
+	case "int":
+		args = append(args, "7")
 	case "stringArray":
 		args = append(args, fmt.Sprintf("test-%s-00", f.Name),
 			fmt.Sprintf("--%s", f.Name),
@@ -122,6 +126,10 @@ func expectedValue(f *pflag.Flag, t *testing.T) interface{} {
 		return true
 	case "string":
 		return fmt.Sprintf("test-%s", f.Name)
+	case "duration":
+		return "1m30s"
+	case "int":
+		return 7
 	case "stringArray":
 		return fmt.Sprintf("[test-%s-00,test-%s-01]", f.Name, f.Name)
 	default:
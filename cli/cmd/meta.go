@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 
@@ -20,6 +21,10 @@ kubectl promdump meta -p <pod> -n <ns>`,
 		SilenceErrors: true, // let main() handles errors
 		SilenceUsage:  true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validatePodOptions(cmd); err != nil {
+				return err
+			}
+
 			if err := setMissingDefaults(cmd); err != nil {
 				return fmt.Errorf("can't set missing defaults: %w", err)
 			}
@@ -28,11 +33,18 @@ kubectl promdump meta -p <pod> -n <ns>`,
 				return fmt.Errorf("validation failed: %w", err)
 			}
 
-			if err := clientset.CanExec(); err != nil {
-				return fmt.Errorf("exec operation denied: %w", err)
+			if err := preflight(appConfig, clientset, os.Stderr); err != nil {
+				return fmt.Errorf("preflight failed: %w", err)
 			}
 
-			return runMeta(cmd, appConfig, clientset)
+			if err := setPodFromSelector(cmd); err != nil {
+				return fmt.Errorf("can't find pod: %w", err)
+			}
+
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			return runMeta(ctx, appConfig, clientset)
 		},
 	}
 
@@ -40,29 +52,38 @@ kubectl promdump meta -p <pod> -n <ns>`,
 	return metaCmd
 }
 
-func runMeta(cmd *cobra.Command, config *config.Config, clientset *k8s.Clientset) error {
-	r := bytes.NewBuffer(promdumpBin)
-	if err := uploadToContainer(r, config, clientset); err != nil {
+func runMeta(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	if err := prepareTransfer(ctx, config, clientset); err != nil {
 		return err
 	}
-	defer func() {
-		_ = clean(config, clientset)
-	}()
 
-	return printMeta(config, clientset)
+	if err := uploadCore(ctx, config, clientset); err != nil {
+		return err
+	}
+	defer cleanupCore(ctx, config, clientset)
+
+	return printMeta(ctx, config, clientset)
 }
 
 func validateMetaOptions(cmd *cobra.Command) error {
 	return nil
 }
 
-func printMeta(config *config.Config, clientset *k8s.Clientset) error {
-	dataDir := config.GetString("data-dir")
-	execCmd := []string{fmt.Sprintf("%s/promdump", dataDir), "-meta",
-		"-data-dir", dataDir}
-	if config.GetBool("debug") {
-		execCmd = append(execCmd, "-debug")
+func printMeta(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	// the output is buffered, so that it isn't printed more than once if the
+	// request is retried
+	var (
+		execCmd = coreCommand(config, "-meta")
+		buf     = &bytes.Buffer{}
+	)
+	err := clientset.Retry(ctx, func() error {
+		buf.Reset()
+		return execCore(ctx, config, clientset, execCmd, nil, buf)
+	})
+	if err != nil {
+		return err
 	}
 
-	return clientset.ExecPod(execCmd, os.Stdin, os.Stdout, os.Stderr, false)
+	_, err = buf.WriteTo(os.Stdout)
+	return err
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+)
+
+// preflight checks all the permissions needed by the subcommand up front, so
+// that it doesn't fail midway. The missing permissions are printed to w, with
+// the roles and role bindings which grant them. Only the missing permissions
+// which aren't optional fail the preflight.
+func preflight(config *config.Config, clientset *k8s.Clientset, w io.Writer) error {
+	opts := k8s.PreflightOptions{
+		Via:            config.GetString("via"),
+		Helper:         config.GetString("pvc") != "",
+		Snapshot:       config.GetBool("snapshot"),
+		Selector:       config.GetString("pod") == "" && config.GetString("pod-selector") != "",
+		DetectPlatform: !strings.HasPrefix(config.GetString("core-source"), coreSourceFilePrefix),
+	}
+	if config.GetBool("restart") {
+		opts.RestartStrategy = config.GetString("restart-strategy")
+	}
+
+	perms := k8s.Permissions(opts)
+	denials, err := clientset.Preflight(perms)
+	if err != nil {
+		return err
+	}
+
+	if len(denials) == 0 {
+		return nil
+	}
+
+	var (
+		missing  = make([]k8s.Permission, 0, len(denials))
+		required int
+	)
+	for _, denial := range denials {
+		missing = append(missing, denial.Permission)
+		if !denial.Optional {
+			required++
+		}
+	}
+
+	ns := config.GetString("namespace")
+	if required == 0 {
+		fmt.Fprintf(w, "missing optional permissions in namespace %s:\n\n", ns)
+	} else {
+		fmt.Fprintf(w, "missing permissions in namespace %s:\n\n", ns)
+	}
+	if err := k8s.PrintDenials(w, denials); err != nil {
+		return err
+	}
+
+	subject, ok := clientset.PreflightSubject()
+	manifest, err := k8s.RBACManifest(ns, subject, missing)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nthe following roles and role bindings grant them:\n\n")
+	if !ok {
+		fmt.Fprintf(w, "# replace %s with your user, group or service account\n", subject.Name)
+	}
+	fmt.Fprintf(w, "%s\n", manifest)
+
+	if required == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d permissions denied", k8s.ErrMissingPermissions, required, len(perms))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ihcsim/promdump/pkg/archive"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+	"github.com/spf13/cobra"
+)
+
+func initRepairCmd(rootCmd *cobra.Command) (*cobra.Command, error) {
+	repairCmd := &cobra.Command{
+		Use:   "repair -t DUMP_FILE [-o OUTPUT_FILE] [--strategy drop|renumber]",
+		Short: "Repairs the out-of-sequence head chunk files of a data dump file.",
+		Example: `# remove the out-of-sequence head chunk files found in the dump.tar.gz file.
+# the repaired dump is saved to the dump-repaired.tar.gz file.
+kubectl promdump repair -t dump.tar.gz
+
+# renumber the head chunk files so that no head data is lost.
+kubectl promdump repair -t dump.tar.gz -o fixed.tar.gz --strategy renumber`,
+		SilenceErrors: true, // let main() handles errors
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// the dump file is repaired locally, without a cluster
+			return initConfig(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateRepairOptions(cmd); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
+			}
+
+			return runRepair(appConfig, os.Stdout)
+		},
+	}
+
+	repairCmd.Flags().StringP("dump-file", "t", "", "path to the sample dump TAR file")
+	repairCmd.Flags().StringP("output", "o", "", "path to the repaired dump TAR file (default <dump-file>-repaired.tar.gz)")
+	repairCmd.Flags().String("strategy", tsdb.RepairDrop, "how to repair the out-of-sequence head chunk files (drop|renumber)")
+	if err := repairCmd.MarkFlagRequired("dump-file"); err != nil {
+		return nil, err
+	}
+
+	rootCmd.AddCommand(repairCmd)
+	return repairCmd, nil
+}
+
+func validateRepairOptions(cmd *cobra.Command) error {
+	strategy, err := cmd.Flags().GetString("strategy")
+	if err != nil {
+		return err
+	}
+
+	if strategy == tsdb.RepairNone {
+		return fmt.Errorf("%s isn't a valid repair strategy", strategy)
+	}
+
+	return tsdb.ValidateRepairStrategy(strategy)
+}
+
+func runRepair(config *config.Config, w io.Writer) error {
+	filename := config.GetString("dump-file")
+	dumpFile, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("can't open dump file: %w", err)
+	}
+	defer dumpFile.Close()
+
+	tempDir, err := os.MkdirTemp("", "promdump-repair")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(dumpFile, tempDir); err != nil {
+		return fmt.Errorf("can't extract data dump: %w", err)
+	}
+
+	db, err := tsdb.New(tempDir, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repair, err := db.RepairHeadChunks(config.GetString("strategy"))
+	if err != nil {
+		return err
+	}
+
+	if len(repair.Dropped) == 0 && len(repair.Renamed) == 0 {
+		fmt.Fprintln(w, "No out-of-sequence head chunk files found")
+		return nil
+	}
+
+	for _, file := range repair.Dropped {
+		fmt.Fprintf(w, "Dropped %s (%d bytes)\n", filepath.Base(file.Path), file.Size)
+	}
+
+	var renamed []string
+	for path := range repair.Renamed {
+		renamed = append(renamed, path)
+	}
+	sort.Strings(renamed)
+	for _, path := range renamed {
+		fmt.Fprintf(w, "Renamed %s to %s\n", filepath.Base(path), repair.Renamed[path])
+	}
+
+	if len(repair.Dropped) > 0 {
+		fmt.Fprintf(w, "Total head data lost: %d bytes\n", repair.LostBytes())
+	}
+
+	output := config.GetString("output")
+	if output == "" {
+		output = strings.TrimSuffix(filename, ".tar.gz") + "-repaired.tar.gz"
+	}
+
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Compress(tempDir, outputFile); err != nil {
+		_ = outputFile.Close()
+		return err
+	}
+
+	if err := outputFile.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Repaired dump file saved to %s\n", output)
+	return nil
+}
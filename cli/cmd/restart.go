@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ihcsim/promdump/pkg/archive"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+)
+
+var errRestoredDataMissing = fmt.Errorf("restored data missing after restart")
+
+// dumpMetadata returns the metadata of the data dump read from r. It is used
+// as the manifest of the dump, to verify the restored data after restart.
+func dumpMetadata(r io.Reader) (*tsdb.Metadata, error) {
+	tempDir, err := os.MkdirTemp("", "promdump-meta")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(r, tempDir); err != nil {
+		return nil, fmt.Errorf("can't extract data dump: %w", err)
+	}
+
+	db, err := tsdb.New(tempDir, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	headMeta, blockMeta, err := db.Meta()
+	if err != nil {
+		return nil, fmt.Errorf("can't read tsdb metadata: %w", err)
+	}
+
+	return &tsdb.Metadata{Head: headMeta, Blocks: blockMeta}, nil
+}
+
+// restartPod restarts the Prometheus pod and waits for it to become ready.
+// The name of the new pod is saved in the config, so that subsequent exec
+// requests are sent to it.
+func restartPod(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	pod, err := clientset.RestartPod(ctx, config.GetString("restart-strategy"), config.GetDuration("restart-timeout"))
+	if err != nil {
+		return err
+	}
+
+	config.Set("pod", pod)
+	fmt.Fprintf(os.Stdout, "Pod %s is ready\n", pod)
+	return nil
+}
+
+// verifyRestore compares the metadata of the restarted Prometheus with the
+// manifest of the data dump. It fails if the restored time range isn't fully
+// covered.
+func verifyRestore(ctx context.Context, config *config.Config, clientset *k8s.Clientset, manifest *tsdb.Metadata) error {
+	// the ephemeral container of the old pod, if any, is gone
+	config.Set("ephemeral-container", "")
+	if err := prepareTransfer(ctx, config, clientset); err != nil {
+		return err
+	}
+
+	if err := uploadCore(ctx, config, clientset); err != nil {
+		return fmt.Errorf("failed to upload promdump: %w", err)
+	}
+	defer cleanupCore(ctx, config, clientset)
+
+	var (
+		execCmd = coreCommand(config, "-meta", "-meta-format", "json")
+		buf     = &bytes.Buffer{}
+	)
+	err := clientset.Retry(ctx, func() error {
+		buf.Reset()
+		return execCore(ctx, config, clientset, execCmd, nil, buf)
+	})
+	if err != nil {
+		return err
+	}
+
+	actual := &tsdb.Metadata{}
+	if err := json.Unmarshal(buf.Bytes(), actual); err != nil {
+		return fmt.Errorf("can't parse tsdb metadata: %w", err)
+	}
+
+	return compareTimeRange(manifest, actual)
+}
+
+func compareTimeRange(expected, actual *tsdb.Metadata) error {
+	expectedMin, expectedMax := expected.TimeRange()
+	if expectedMin.IsZero() && expectedMax.IsZero() {
+		return nil
+	}
+
+	actualMin, actualMax := actual.TimeRange()
+	if actualMin.IsZero() || actualMin.After(expectedMin) || actualMax.Before(expectedMax) {
+		return fmt.Errorf("%w: expected time range %s - %s, actual: %s - %s",
+			errRestoredDataMissing,
+			formatTime(expectedMin), formatTime(expectedMax),
+			formatTime(actualMin), formatTime(actualMax))
+	}
+
+	fmt.Fprintf(os.Stdout, "Restored time range %s - %s is available\n",
+		formatTime(expectedMin), formatTime(expectedMax))
+	return nil
+}
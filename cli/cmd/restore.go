@@ -2,48 +2,164 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/ihcsim/promdump/pkg/archive"
 	"github.com/ihcsim/promdump/pkg/config"
 	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/status"
+	"github.com/ihcsim/promdump/pkg/tsdb"
 	"github.com/spf13/cobra"
 )
 
 func initRestoreCmd(rootCmd *cobra.Command) (*cobra.Command, error) {
 	restoreCmd := &cobra.Command{
-		Use:   "restore -p POD [-n NAMESPACE] [-c CONTAINER] [-d DATA_DIR]",
+		Use:   "restore -p POD (-t DUMP_FILE | --undo BACKUP_FILE) [-n NAMESPACE] [-c CONTAINER] [-d DATA_DIR]",
 		Short: "Restores data dump to a Prometheus instance.",
 		Example: `# copy and restore the data dump in the dump.tar.gz file to the Prometheus
 # <pod> in namespace <ns>.
-kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz`,
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz
+
+# rewrite the series in the dump.tar.gz file with the relabel_configs found in
+# the relabel.yaml file, before restoring them.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --relabel-config relabel.yaml
+
+# restore the data dump without verifying its integrity before and after the
+# restoration.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --skip-check
+
+# restart the Prometheus pod after the restoration, and verify that the
+# restored time range is available.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --restart
+
+# restart the Prometheus pod by rolling out its StatefulSet or Deployment.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --restart --restart-strategy rollout
+
+# the existing data of the Prometheus <pod> is backed up to the
+# pre-restore-<pod>-<timestamp>.tar.gz file, before it is replaced by the data
+# dump. use the --undo option to put the backup back.
+kubectl promdump restore -p <pod> -n <ns> --undo pre-restore-<pod>-20210418-203521.tar.gz
+
+# restore the data dump without backing up the existing data.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --no-backup
+
+# restore the data dump without confirmation, unless the current context
+# matches *prod*.
+kubectl promdump restore -p <pod> -n <ns> -t dump.tar.gz --yes --deny-context "*prod*"`,
 		SilenceErrors: true, // let main() handles errors
 		SilenceUsage:  true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validatePodOptions(cmd); err != nil {
+				return err
+			}
+
 			if err := setMissingDefaults(cmd); err != nil {
 				return fmt.Errorf("can't set missing defaults: %w", err)
 			}
 
-			if err := clientset.CanExec(); err != nil {
-				return fmt.Errorf("exec operation denied: %w", err)
+			if err := validateRestoreOptions(cmd); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
+			}
+
+			if err := validateRewriteOptions(cmd); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
+			}
+
+			if err := validateRestartOptions(cmd); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
 			}
 
-			return runRestore(appConfig, clientset)
+			if err := preflight(appConfig, clientset, os.Stderr); err != nil {
+				return fmt.Errorf("preflight failed: %w", err)
+			}
+
+			if err := setPodFromSelector(cmd); err != nil {
+				return fmt.Errorf("can't find pod: %w", err)
+			}
+
+			if err := checkGuardrails(appConfig, clientset); err != nil {
+				return fmt.Errorf("restore operation denied: %w", err)
+			}
+
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			return runRestore(ctx, appConfig, clientset)
 		},
 	}
 
 	restoreCmd.Flags().StringP("dump-file", "t", "", "path to the sample dump TAR file")
-	if err := restoreCmd.MarkFlagRequired("dump-file"); err != nil {
-		return nil, err
-	}
+	restoreCmd.Flags().String("relabel-config", "", "path to a YAML file with Prometheus relabel_configs to apply to the restored series")
+	restoreCmd.Flags().Bool("skip-check", false, "skip the integrity check of the data dump before and after the restoration")
+	restoreCmd.Flags().Bool("restart", false, "restart the Prometheus pod after the restoration, and verify the restored time range")
+	restoreCmd.Flags().String("restart-strategy", k8s.RestartDelete, "how to restart the Prometheus pod (delete|rollout)")
+	restoreCmd.Flags().Duration("restart-timeout", defaultRestartTimeout, "how long to wait for the restarted Prometheus pod to become ready")
+	restoreCmd.Flags().String("undo", "", "path to a pre-restore backup TAR file to put back")
+	restoreCmd.Flags().Bool("no-backup", false, "don't back up the existing data of the Prometheus pod before the restoration")
+	restoreCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation before deleting the existing data of the Prometheus pod")
+	restoreCmd.Flags().StringSlice("deny-context", nil, "kubeconfig contexts (glob patterns) that can't be restored to. also read from the "+envDenyContexts+" environment variable")
+	restoreCmd.Flags().StringSlice("deny-namespace", nil, "namespaces (glob patterns) that can't be restored to. also read from the "+envDenyNamespaces+" environment variable")
 
 	rootCmd.AddCommand(restoreCmd)
 	return restoreCmd, nil
 }
 
-func runRestore(config *config.Config, clientset *k8s.Clientset) error {
-	filename := config.GetString("dump-file")
+func validateRestoreOptions(cmd *cobra.Command) error {
+	dumpFile, err := cmd.Flags().GetString("dump-file")
+	if err != nil {
+		return err
+	}
+
+	undo, err := cmd.Flags().GetString("undo")
+	if err != nil {
+		return err
+	}
+
+	if dumpFile == "" && undo == "" {
+		return fmt.Errorf(`one of the "dump-file" or "undo" flags must be set`)
+	}
+
+	if dumpFile != "" && undo != "" {
+		return fmt.Errorf(`the "dump-file" and "undo" flags can't be used together`)
+	}
+
+	relabelConfig, err := cmd.Flags().GetString("relabel-config")
+	if err != nil {
+		return err
+	}
+
+	if undo != "" && relabelConfig != "" {
+		return fmt.Errorf(`the "undo" and "relabel-config" flags can't be used together`)
+	}
+
+	return nil
+}
+
+func validateRestartOptions(cmd *cobra.Command) error {
+	strategy, err := cmd.Flags().GetString("restart-strategy")
+	if err != nil {
+		return err
+	}
+
+	return k8s.ValidateRestartStrategy(strategy)
+}
+
+func runRestore(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	if err := prepareTransfer(ctx, config, clientset); err != nil {
+		return err
+	}
+
+	var (
+		filename = config.GetString("dump-file")
+		undo     = config.GetString("undo")
+	)
+	if undo != "" {
+		filename = undo
+	}
+
 	dumpFile, err := os.Open(filename)
 	if err != nil {
 		return fmt.Errorf("can't open dump file: %w", err)
@@ -54,11 +170,132 @@ func runRestore(config *config.Config, clientset *k8s.Clientset) error {
 		return fmt.Errorf("can't read sample dump file: %w", err)
 	}
 
-	dataDir := config.GetString("data-dir")
-	execCmd := []string{"sh", "-c", fmt.Sprintf("rm -rf %s/*", dataDir)}
-	if err := clientset.ExecPod(execCmd, os.Stdin, os.Stdout, os.Stderr, false); err != nil {
+	if needsRewrite(config) {
+		buf := &bytes.Buffer{}
+		if err := rewriteDump(bytes.NewReader(data), buf, config); err != nil {
+			return fmt.Errorf("can't rewrite sample dump: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	var manifest *tsdb.Metadata
+	if config.GetBool("restart") {
+		if manifest, err = dumpMetadata(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	skipCheck := config.GetBool("skip-check")
+	if !skipCheck {
+		fmt.Fprintf(os.Stdout, "Checking data dump %s\n", filename)
+		if err := checkDump(bytes.NewReader(data), os.Stdout); err != nil {
+			return fmt.Errorf("refusing to restore sample dump: %w", err)
+		}
+	}
+
+	if err := confirmRestore(ctx, config, clientset, os.Stdin, os.Stdout); err != nil {
+		return err
+	}
+
+	// there's no need to back up the data that is replaced by a backup
+	if undo == "" && !config.GetBool("no-backup") {
+		// the backup is written to the current directory
+		backup, err := backupPod(ctx, config, clientset, ".")
+		if err != nil {
+			return fmt.Errorf("refusing to restore sample dump: %w", err)
+		}
+		fmt.Fprintf(os.Stdout, "Existing data backed up to %s. Use the --undo option to put it back.\n", backup)
+	}
+
+	wipe := func(dataDir string) []string {
+		return []string{"sh", "-c", fmt.Sprintf("rm -rf %s/*", dataDir)}
+	}
+	if err := execDataDir(ctx, config, clientset, wipe, nil, os.Stdout); err != nil {
+		return err
+	}
+
+	if err := uploadDump(ctx, data, config, clientset); err != nil {
+		return err
+	}
+
+	if !skipCheck {
+		fmt.Fprintf(os.Stdout, "Checking restored data in pod %s\n", config.GetString("pod"))
+		if err := checkPod(ctx, config, clientset); err != nil {
+			return err
+		}
+	}
+
+	if manifest == nil {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "Restarting pod %s\n", config.GetString("pod"))
+	if err := restartPod(ctx, config, clientset); err != nil {
+		return err
+	}
+
+	return verifyRestore(ctx, config, clientset, manifest)
+}
+
+// uploadDump extracts the data dump into the data directory, reporting the
+// progress of the upload.
+func uploadDump(ctx context.Context, data []byte, config *config.Config, clientset *k8s.Clientset) error {
+	var r io.Reader = bytes.NewReader(data)
+	if reporter := newProgress(config, "upload"); reporter != nil {
+		r = reporter.WithTotal(int64(len(data))).Reader(r)
+		defer reporter.Done()
+	}
+
+	return uploadToContainer(ctx, r, config, clientset)
+}
+
+// checkDump verifies the integrity of the data dump read from r, writing the
+// results to w.
+func checkDump(r io.Reader, w io.Writer) error {
+	tempDir, err := os.MkdirTemp("", "promdump-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(r, tempDir); err != nil {
+		return fmt.Errorf("can't extract data dump: %w", err)
+	}
+
+	db, err := tsdb.New(tempDir, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := db.Check()
+	if err != nil {
 		return err
 	}
 
-	return uploadToContainer(bytes.NewBuffer(data), config, clientset)
+	for _, result := range results {
+		fmt.Fprintln(w, result)
+	}
+
+	if tsdb.CheckFailed(results) {
+		return status.ErrIntegrityCheckFailed
+	}
+
+	return nil
+}
+
+// checkPod verifies the integrity of the restored data by running the
+// promdump binary in the check mode.
+func checkPod(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	if err := uploadCore(ctx, config, clientset); err != nil {
+		return fmt.Errorf("failed to upload promdump: %w", err)
+	}
+	defer cleanupCore(ctx, config, clientset)
+
+	execCmd := coreCommand(config, "-check")
+	if err := execCore(ctx, config, clientset, execCmd, nil, os.Stdout); err != nil {
+		return fmt.Errorf("integrity check of the restored data failed: %w", err)
+	}
+
+	return nil
 }
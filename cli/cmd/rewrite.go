@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/archive"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/tsdb"
+	"github.com/prometheus/common/model"
+)
+
+// needsRewrite returns true if the user requested the data dump to be
+// rewritten locally.
+func needsRewrite(config *config.Config) bool {
+	return config.GetString("relabel-config") != "" ||
+		config.GetString("downsample") != ""
+}
+
+// parseResolution parses a downsample resolution like 5m or 1h.
+func parseResolution(resolution string) (time.Duration, error) {
+	d, err := model.ParseDuration(resolution)
+	if err != nil {
+		return 0, fmt.Errorf("invalid downsample resolution: %w", err)
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid downsample resolution: %s", resolution)
+	}
+
+	return time.Duration(d), nil
+}
+
+// rewriteDump extracts the data dump read from r into a temporary directory,
+// relabels and downsamples its series according to the user's configuration
+// and writes the new data dump to w. The head block of the dump is persisted
+// into a block before the rewrite.
+func rewriteDump(r io.Reader, w io.Writer, config *config.Config) error {
+	tempDir, err := os.MkdirTemp("", "promdump-rewrite")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := archive.Extract(r, tempDir); err != nil {
+		return fmt.Errorf("can't extract data dump: %w", err)
+	}
+
+	db, err := tsdb.New(tempDir, logger)
+	if err != nil {
+		return err
+	}
+
+	if err := rewriteTsdb(db, config); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return archive.Compress(tempDir, w)
+}
+
+func rewriteTsdb(db *tsdb.Tsdb, config *config.Config) error {
+	if err := db.FlushHead(); err != nil {
+		return err
+	}
+
+	if filename := config.GetString("relabel-config"); filename != "" {
+		configs, err := tsdb.LoadRelabelConfigs(filename)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Relabel(configs); err != nil {
+			return err
+		}
+	}
+
+	if resolution := config.GetString("downsample"); resolution != "" {
+		d, err := parseResolution(resolution)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Downsample(d, config.GetString("downsample-mode")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,18 +1,23 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ihcsim/promdump/pkg/log"
 
+	"github.com/ihcsim/promdump/pkg/archive"
 	"github.com/ihcsim/promdump/pkg/config"
 	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/status"
+	"github.com/ihcsim/promdump/pkg/timerange"
+	"github.com/ihcsim/promdump/pkg/tsdb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	k8scliopts "k8s.io/cli-runtime/pkg/genericclioptions"
@@ -28,11 +33,17 @@ var (
 	defaultContainer      = "prometheus-server"
 	defaultDataDir        = "/data"
 	defaultDebugEnabled   = false
-	defaultMaxTime        = time.Now()
+	defaultMaxTime        = timerange.Now
 	defaultLogLevel       = "error"
 	defaultNamespace      = "default"
-	defaultMinTime        = defaultMaxTime.Add(-1 * time.Hour)
+	defaultMinTime        = "now-1h"
 	defaultRequestTimeout = "10s"
+	defaultRestartTimeout = 5 * time.Minute
+	defaultTimeout        = time.Duration(0)
+
+	defaultExecRetries          = 3
+	defaultExecRetryInterval    = time.Second
+	defaultExecRetryMaxInterval = 30 * time.Second
 
 	appConfig      *config.Config
 	clientset      *k8s.Clientset
@@ -43,16 +54,38 @@ var (
 	// Version is the version of the CLI, set during build time
 	Version = "v0.1.0"
 	Commit  = "unknown"
+
+	// CorePublicKey is the minisign public key which verifies the downloaded
+	// promdump binaries, set during build time
+	CorePublicKey = ""
 )
 
 func initRootCmd() (*cobra.Command, error) {
 	rootCmd := &cobra.Command{
-		Use:   `promdump -p POD --min-time "yyyy-mm-dd hh:mm:ss" --max-time "yyyy-mm-dd hh:mm:ss" [-n NAMESPACE] [-c CONTAINER] [-d DATA_DIR]`,
+		Use:   `promdump (-p POD | --pod-selector SELECTOR | --pvc PVC) --min-time "yyyy-mm-dd hh:mm:ss" --max-time "yyyy-mm-dd hh:mm:ss" [-n NAMESPACE] [-c CONTAINER] [-d DATA_DIR]`,
 		Short: "promdump dumps the head and persistent blocks of Prometheus",
 		Example: `# dumps the head block and persistent blocks between
 # 2021-01-01 00:00:00 and 2021-04-02 16:59:00, from the Prometheus <pod> in the
 # <ns> namespace.
-kubectl promdump -p <pod> -n <ns> --min-time "2021-01-01 00:00:00" --max-time "2021-04-02 16:59:00" > dump.tar.gz`,
+kubectl promdump -p <pod> -n <ns> --min-time "2021-01-01 00:00:00" --max-time "2021-04-02 16:59:00" > dump.tar.gz
+
+# same as above, but rewrites the dumped series with the relabel_configs found
+# in the relabel.yaml file.
+kubectl promdump -p <pod> -n <ns> --min-time "2021-01-01 00:00:00" --max-time "2021-04-02 16:59:00" --relabel-config relabel.yaml > dump.tar.gz
+
+# dumps the data found in the <pvc> persistent volume claim of a crash-looping
+# Prometheus, using a helper pod. the data directory of Prometheus is the
+# prometheus-db sub-path of the volume.
+kubectl promdump --pvc <pvc> -n <ns> --pvc-sub-path prometheus-db --min-time "2021-01-01 00:00:00" --max-time "2021-04-02 16:59:00" > dump.tar.gz
+
+# dumps the data from a volume snapshot of the persistent volume claim mounted
+# by the Prometheus <pod>.
+kubectl promdump -p <pod> -n <ns> --snapshot --min-time "2021-01-01 00:00:00" --max-time "2021-04-02 16:59:00" > dump.tar.gz
+
+# dumps the data 30 minutes before and after the incident of the
+# HighErrorRate alert in the last 24 hours. if there are more than one, they
+# are listed, and one of them is selected with --incident.
+kubectl promdump -p <pod> -n <ns> --alert HighErrorRate --padding 30m > dump.tar.gz`,
 		Long: `promdump dumps the head and persistent blocks of Prometheus. It supports
 filtering the persistent blocks by time range.
 
@@ -70,6 +103,10 @@ https://github.com/ihcsim/promdump.
 		SilenceErrors: true, // let main() handles errors
 		SilenceUsage:  true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateSourceOptions(cmd); err != nil {
+				return err
+			}
+
 			if err := setMissingDefaults(cmd); err != nil {
 				return fmt.Errorf("can't set missing defaults: %w", err)
 			}
@@ -78,31 +115,25 @@ https://github.com/ihcsim/promdump.
 				return fmt.Errorf("validation failed: %w", err)
 			}
 
-			if err := clientset.CanExec(); err != nil {
-				return fmt.Errorf("exec operation denied: %w", err)
+			if err := preflight(appConfig, clientset, os.Stderr); err != nil {
+				return fmt.Errorf("preflight failed: %w", err)
 			}
 
-			return run(cmd, appConfig, clientset)
-		},
-		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			var err error
-			appConfig, err = config.New(cmd.Flags())
-			if err != nil {
-				return fmt.Errorf("failed to init viper config: %w", err)
+			if err := setPodFromSelector(cmd); err != nil {
+				return fmt.Errorf("can't find pod: %w", err)
 			}
 
-			k8sConfig, err := k8sConfig(k8sConfigFlags, cmd.Flags())
-			if err != nil {
-				return fmt.Errorf("failed to init k8s config: %w", err)
-			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
 
-			initLogger()
-			clientset, err = k8s.NewClientset(appConfig, k8sConfig, logger)
-			if err != nil {
-				return fmt.Errorf("failed to init k8s client: %w", err)
+			return run(ctx, appConfig, clientset)
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := initConfig(cmd); err != nil {
+				return err
 			}
 
-			return nil
+			return initClientset(cmd)
 		},
 	}
 
@@ -110,23 +141,107 @@ https://github.com/ihcsim/promdump.
 	k8sConfigFlags = k8scliopts.NewConfigFlags(true)
 	k8sConfigFlags.AddFlags(rootCmd.PersistentFlags())
 
+	rootCmd.PersistentFlags().String("config", "", "path to the promdump config file. defaults to ~/.config/promdump/config.yaml")
+	rootCmd.PersistentFlags().String("profile", "", "profile of the config file to use. defaults to the current profile of the config file")
 	rootCmd.PersistentFlags().StringP("pod", "p", "", "Prometheus pod name")
+	rootCmd.PersistentFlags().String("pod-selector", "", "label selector of the Prometheus pod, used if the pod name isn't specified (e.g. app.kubernetes.io/name=prometheus)")
 	rootCmd.PersistentFlags().StringP("container", "c", defaultContainer, "Prometheus container name")
 	rootCmd.PersistentFlags().StringP("data-dir", "d", defaultDataDir, "Prometheus data directory")
 	rootCmd.PersistentFlags().Bool("debug", defaultDebugEnabled, "run promdump in debug mode")
-	rootCmd.Flags().String("min-time", defaultMinTime.Format(timeFormat), "min time (UTC) of the samples (yyyy-mm-dd hh:mm:ss)")
-	rootCmd.Flags().String("max-time", defaultMaxTime.Format(timeFormat), "max time (UTC) of the samples (yyyy-mm-dd hh:mm:ss)")
+	rootCmd.PersistentFlags().Duration("timeout", defaultTimeout, "timeout of the whole operation (e.g. 10m). zero means no timeout")
+	rootCmd.PersistentFlags().Int("exec-retries", defaultExecRetries, "number of retries of idempotent exec requests (e.g. upload, meta, clean-up) after transient failures")
+	rootCmd.PersistentFlags().Duration("exec-retry-interval", defaultExecRetryInterval, "initial interval between retries of exec requests. it doubles after every retry, with jitter")
+	rootCmd.PersistentFlags().Duration("exec-retry-max-interval", defaultExecRetryMaxInterval, "maximum interval between retries of exec requests")
+	rootCmd.PersistentFlags().String("exec-transport", k8s.ExecTransportAuto, "transport of the exec requests: spdy, websocket, or auto to fall back to websocket when the spdy upgrade fails (e.g. behind proxies)")
+	rootCmd.PersistentFlags().String("core-source", defaultCoreSource, "source of the promdump binary: embedded (the builds which aren't embedded are downloaded), download, or file:<path> to a local promdump TAR file")
+	rootCmd.PersistentFlags().String("core-base-url", defaultCoreBaseURL, "base URL of the releases to download the promdump binary from. the binary is downloaded from <base-url>/<version>/promdump-linux-<arch>-<version>.tar.gz")
+	rootCmd.PersistentFlags().String("core-cache-dir", "", "directory where the downloaded promdump binaries are cached. defaults to the promdump directory of the user cache directory")
+	rootCmd.PersistentFlags().Int("core-download-retries", defaultCoreDownloadRetries, "maximum number of retries of the download of the promdump binary after transient failures. interrupted downloads are resumed")
+	rootCmd.PersistentFlags().Int("core-download-max-size", defaultCoreDownloadMaxSize, "maximum size of the downloaded promdump binary in bytes")
+	rootCmd.PersistentFlags().String("core-download-proxy", "", "URL of the proxy to download the promdump binary through. defaults to the proxy of the HTTPS_PROXY environment variable")
+	rootCmd.PersistentFlags().Bool("insecure-skip-signature-verification", false, "run a downloaded promdump binary without verifying its signature, if the CLI is built without a public key")
+	rootCmd.PersistentFlags().String("core-download-ca-file", "", "path to a PEM file of the CA certificates to trust, in addition to the system ones, when downloading the promdump binary")
+	rootCmd.PersistentFlags().Bool("progress", true, "report the progress of the transfers on stderr. it's disabled if stderr isn't a terminal")
+	rootCmd.PersistentFlags().String("via", k8s.ViaExec, "where the promdump binary runs: in the Prometheus container (exec), or in an ephemeral container attached to the Prometheus pod (ephemeral)")
+	rootCmd.PersistentFlags().String("ephemeral-image", k8s.EphemeralImage(), "image of the ephemeral container and the helper pod. can be overridden with the "+k8s.EnvEphemeralImage+" environment variable")
+	rootCmd.Flags().String("pvc", "", "dump the data of the Prometheus persistent volume claim, using a helper pod, instead of a running Prometheus pod")
+	rootCmd.Flags().String("pvc-sub-path", "", "path of the Prometheus data directory within the persistent volume claim")
+	rootCmd.Flags().Bool("snapshot", false, "dump from a CSI volume snapshot of the Prometheus persistent volume claim, for a crash-consistent capture")
+	rootCmd.Flags().String("snapshot-class", "", "volume snapshot class of the volume snapshot. the default class is used if empty")
+	rootCmd.Flags().String("min-time", defaultMinTime, "min time of the samples: an RFC3339 time (e.g. 2021-04-18T20:00:00+02:00), a time in the --tz time zone (yyyy-mm-dd hh:mm:ss), a Unix timestamp, or a relative time (e.g. now-6h or -6h)")
+	rootCmd.Flags().String("max-time", defaultMaxTime, "max time of the samples, in the formats of --min-time")
+	rootCmd.Flags().String("since", "", "dump the samples of this duration (e.g. 3h) until now, instead of --min-time and --max-time")
+	rootCmd.Flags().String("around", "", "dump the samples around this time, in the formats of --min-time, instead of --min-time and --max-time")
+	rootCmd.Flags().String("window", "", "duration before and after --around to dump (default "+timerange.DefaultWindow+")")
+	rootCmd.Flags().String("tz", "", "time zone of the times without offset (e.g. Europe/Berlin). defaults to UTC")
+	rootCmd.Flags().String("alert", "", "dump the samples around an incident of this Prometheus alert, found in the ALERTS series, instead of --min-time and --max-time")
+	rootCmd.Flags().Duration("padding", defaultAlertPadding, "duration before and after the incident of --alert to dump")
+	rootCmd.Flags().Duration("lookback", defaultAlertLookback, "duration until now in which the incidents of --alert are looked for")
+	rootCmd.Flags().String("incident", "", "incident of --alert to dump if there are more than one: its index in the list of incidents, or latest")
+	rootCmd.Flags().String("relabel-config", "", "path to a YAML file with Prometheus relabel_configs to apply to the dumped series")
+	rootCmd.Flags().String("downsample", "", "resolution (e.g. 5m) to downsample the dumped series to")
+	rootCmd.Flags().String("downsample-mode", tsdb.DownsampleAuto, "downsample mode (auto|aggregate)")
+	rootCmd.Flags().String("head-chunks-repair", tsdb.RepairDrop, "how to handle out-of-sequence head chunk files (drop|renumber|none)")
 
 	rootCmd.Flags().SortFlags = false
-	if err := rootCmd.MarkPersistentFlagRequired("pod"); err != nil {
-		return nil, err
-	}
 
 	setPluginUsageTemplate(rootCmd)
 
 	return rootCmd, nil
 }
 
+// commandContext returns the context of cmd, which is cancelled on SIGINT and
+// SIGTERM, with the timeout of the whole operation.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if timeout := appConfig.GetDuration("timeout"); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// initConfig initializes the application config and logger. It is used by
+// subcommands that don't need to connect to the cluster.
+func initConfig(cmd *cobra.Command) error {
+	if err := applyProfile(cmd); err != nil {
+		return fmt.Errorf("failed to apply profile: %w", err)
+	}
+
+	var err error
+	appConfig, err = config.New(cmd.Flags())
+	if err != nil {
+		return fmt.Errorf("failed to init viper config: %w", err)
+	}
+
+	initLogger()
+	return nil
+}
+
+func initClientset(cmd *cobra.Command) error {
+	k8sConfig, err := k8sConfig(k8sConfigFlags, cmd.Flags())
+	if err != nil {
+		return fmt.Errorf("failed to init k8s config: %w", err)
+	}
+
+	clientset, err = k8s.NewClientset(appConfig, k8sConfig, logger)
+	if err != nil {
+		return fmt.Errorf("failed to init k8s client: %w", err)
+	}
+
+	// the resolved context is shown to the user before destructive operations
+	context, err := currentContext(k8sConfigFlags, cmd.Flags())
+	if err != nil {
+		return err
+	}
+	appConfig.Set("context", context)
+
+	return nil
+}
+
 func setMissingDefaults(cmd *cobra.Command) error {
 	ns, err := cmd.Flags().GetString("namespace")
 	if err != nil {
@@ -153,60 +268,268 @@ func setMissingDefaults(cmd *cobra.Command) error {
 	return nil
 }
 
-func validateRootOptions(cmd *cobra.Command) error {
-	argMinTime, err := cmd.Flags().GetString("min-time")
+// setPodFromSelector sets the pod flag to the pod matching the pod selector,
+// if the pod isn't specified. The pod isn't needed to dump a persistent volume
+// claim. It runs after the preflight checks, which include the permission to
+// list the pods.
+func setPodFromSelector(cmd *cobra.Command) error {
+	pod, err := cmd.Flags().GetString("pod")
+	if err != nil {
+		return err
+	}
+
+	selector, err := cmd.Flags().GetString("pod-selector")
+	if err != nil {
+		return err
+	}
+
+	if pod != "" || selector == "" {
+		return nil
+	}
+
+	if pvc := cmd.Flags().Lookup("pvc"); pvc != nil && pvc.Value.String() != "" {
+		return nil
+	}
+
+	if pod, err = clientset.FindPod(cmd.Context(), selector); err != nil {
+		return err
+	}
+
+	return cmd.Flags().Set("pod", pod)
+}
+
+// validatePodOptions ensures that the targeted Prometheus pod is specified, and
+// that the way to access it is valid. The pod flag isn't marked as required,
+// because it doesn't apply to the offline subcommands.
+func validatePodOptions(cmd *cobra.Command) error {
+	pod, err := cmd.Flags().GetString("pod")
+	if err != nil {
+		return err
+	}
+
+	selector, err := cmd.Flags().GetString("pod-selector")
+	if err != nil {
+		return err
+	}
+
+	if pod == "" && selector == "" {
+		return fmt.Errorf(`required flag(s) "pod" or "pod-selector" not set`)
+	}
+
+	via, err := cmd.Flags().GetString("via")
+	if err != nil {
+		return err
+	}
+
+	if err := k8s.ValidateVia(via); err != nil {
+		return err
+	}
+
+	return validateExecOptions(cmd)
+}
+
+// validateExecOptions validates the options of the exec requests, and of the
+// promdump binary they run.
+func validateExecOptions(cmd *cobra.Command) error {
+	transport, err := cmd.Flags().GetString("exec-transport")
+	if err != nil {
+		return err
+	}
+
+	if err := k8s.ValidateExecTransport(transport); err != nil {
+		return err
+	}
+
+	source, err := cmd.Flags().GetString("core-source")
+	if err != nil {
+		return err
+	}
+
+	return validateCoreSource(source)
+}
+
+// validateSourceOptions ensures that either a Prometheus pod or a persistent
+// volume claim is targeted by the dump. With --snapshot, the persistent volume
+// claim can also be found from the pod.
+func validateSourceOptions(cmd *cobra.Command) error {
+	pvc, err := cmd.Flags().GetString("pvc")
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := cmd.Flags().GetBool("snapshot")
+	if err != nil {
+		return err
+	}
+
+	if pvc == "" {
+		if err := validatePodOptions(cmd); err != nil || !snapshot {
+			return err
+		}
+		return validateHelperOptions(cmd)
+	}
+
+	pod, err := cmd.Flags().GetString("pod")
 	if err != nil {
 		return err
 	}
 
-	argMaxTime, err := cmd.Flags().GetString("max-time")
+	if pod != "" && !snapshot {
+		return fmt.Errorf(`flags "pod" and "pvc" are mutually exclusive`)
+	}
+
+	return validateHelperOptions(cmd)
+}
+
+// validateHelperOptions ensures that the options apply to the helper pod,
+// which is used to dump from persistent volume claims and volume snapshots.
+func validateHelperOptions(cmd *cobra.Command) error {
+	via, err := cmd.Flags().GetString("via")
 	if err != nil {
 		return err
 	}
 
-	minTime, err := time.Parse(timeFormat, argMinTime)
+	if via != k8s.ViaExec {
+		return fmt.Errorf(`flags "pvc" and "snapshot" can't be used with "--via %s"`, via)
+	}
+
+	return validateExecOptions(cmd)
+}
+
+func validateRootOptions(cmd *cobra.Command) error {
+	if err := validateAlertOptions(cmd); err != nil {
+		return err
+	}
+
+	// the time range of an alert is resolved from its incidents, once the
+	// promdump binary is uploaded
+	if alert, _ := cmd.Flags().GetString("alert"); alert == "" {
+		if err := resolveTimeRange(cmd, os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRewriteOptions(cmd); err != nil {
+		return err
+	}
+
+	if err := validateDownsampleOptions(cmd); err != nil {
+		return err
+	}
+
+	repair, err := cmd.Flags().GetString("head-chunks-repair")
 	if err != nil {
 		return err
 	}
 
-	maxTime, err := time.Parse(timeFormat, argMaxTime)
+	return tsdb.ValidateRepairStrategy(repair)
+}
+
+// resolveTimeRange resolves the time range of the time options, and sets the
+// min-time and max-time flags to its RFC3339 times in UTC. The time range is
+// echoed to w.
+func resolveTimeRange(cmd *cobra.Command, w io.Writer) error {
+	var (
+		opts  timerange.Options
+		flags = map[string]*string{
+			"min-time": &opts.MinTime,
+			"max-time": &opts.MaxTime,
+			"since":    &opts.Since,
+			"around":   &opts.Around,
+			"window":   &opts.Window,
+			"tz":       &opts.TZ,
+		}
+	)
+	for name, value := range flags {
+		// the min and max times only conflict with the other options if
+		// they are set explicitly
+		if flag := cmd.Flags().Lookup(name); flag.Changed {
+			*value = flag.Value.String()
+		}
+	}
+
+	r, err := timerange.Resolve(opts, time.Now(), defaultMinTime, defaultMaxTime)
 	if err != nil {
 		return err
 	}
 
-	if minTime.After(maxTime) {
-		return fmt.Errorf("min time (%s) cannot be after max time (%s)", argMinTime, argMaxTime)
+	if err := cmd.Flags().Set("min-time", r.Min.Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+
+	if err := cmd.Flags().Set("max-time", r.Max.Format(time.RFC3339Nano)); err != nil {
+		return err
 	}
 
-	now := time.Now().UTC()
-	if minTime.After(now) {
-		return fmt.Errorf("min time (%s) cannot be after now (%s)", argMinTime, now.Format(timeFormat))
+	_, err = fmt.Fprintf(w, "time range: %s\n", r)
+	return err
+}
+
+func validateRewriteOptions(cmd *cobra.Command) error {
+	relabelConfig, err := cmd.Flags().GetString("relabel-config")
+	if err != nil {
+		return err
 	}
 
-	if maxTime.After(now) {
-		return fmt.Errorf("max time (%s) cannot be after now (%s)", argMaxTime, now.Format(timeFormat))
+	if relabelConfig != "" {
+		if _, err := tsdb.LoadRelabelConfigs(relabelConfig); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func k8sConfig(k8sConfigFlags *k8scliopts.ConfigFlags, fs *pflag.FlagSet) (*rest.Config, error) {
+func validateDownsampleOptions(cmd *cobra.Command) error {
+	resolution, err := cmd.Flags().GetString("downsample")
+	if err != nil {
+		return err
+	}
+
+	if resolution == "" {
+		return nil
+	}
+
+	if _, err := parseResolution(resolution); err != nil {
+		return err
+	}
+
+	mode, err := cmd.Flags().GetString("downsample-mode")
+	if err != nil {
+		return err
+	}
+
+	return tsdb.ValidateDownsampleMode(mode)
+}
+
+// currentContext returns the name of the kubeconfig context in use.
+func currentContext(k8sConfigFlags *k8scliopts.ConfigFlags, fs *pflag.FlagSet) (string, error) {
 	// read from CLI flags first
 	// then if empty, load defaults from config loader
 	currentContext, err := fs.GetString("context")
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	configLoader := k8sConfigFlags.ToRawKubeConfigLoader()
 	if currentContext == "" {
-		rawConfig, err := configLoader.RawConfig()
+		rawConfig, err := k8sConfigFlags.ToRawKubeConfigLoader().RawConfig()
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		currentContext = rawConfig.CurrentContext
 	}
 
+	return currentContext, nil
+}
+
+func k8sConfig(k8sConfigFlags *k8scliopts.ConfigFlags, fs *pflag.FlagSet) (*rest.Config, error) {
+	currentContext, err := currentContext(k8sConfigFlags, fs)
+	if err != nil {
+		return nil, err
+	}
+
+	configLoader := k8sConfigFlags.ToRawKubeConfigLoader()
+
 	timeout, err := fs.GetString("request-timeout")
 	if err != nil {
 		return nil, err
@@ -231,52 +554,151 @@ func k8sConfig(k8sConfigFlags *k8scliopts.ConfigFlags, fs *pflag.FlagSet) (*rest
 		}).ClientConfig()
 }
 
-func run(cmd *cobra.Command, config *config.Config, clientset *k8s.Clientset) error {
-	r := bytes.NewReader(promdumpBin)
-	if err := uploadToContainer(r, config, clientset); err != nil {
+func run(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	switch {
+	case config.GetBool("snapshot"):
+		cleanup, err := startSnapshotHelperPod(ctx, config, clientset)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	case config.GetString("pvc") != "":
+		deleteHelperPod, err := startHelperPod(ctx, config, clientset)
+		if err != nil {
+			return err
+		}
+		defer deleteHelperPod()
+	default:
+		if err := prepareTransfer(ctx, config, clientset); err != nil {
+			return err
+		}
+	}
+
+	if err := uploadCore(ctx, config, clientset); err != nil {
 		return err
 	}
-	defer func() {
-		_ = clean(config, clientset)
-	}()
+	defer cleanupCore(ctx, config, clientset)
+
+	if config.GetString("alert") != "" {
+		if err := resolveAlertTimeRange(ctx, config, clientset, os.Stderr); err != nil {
+			return err
+		}
+	}
 
-	return dumpSamples(config, clientset)
+	return dumpSamples(ctx, config, clientset)
 }
 
-func uploadToContainer(bin io.Reader, config *config.Config, clientset *k8s.Clientset) error {
-	dataDir := config.GetString("data-dir")
-	execCmd := []string{"tar", "-C", dataDir, "-xzvf", "-"}
-	return clientset.ExecPod(execCmd, bin, io.Discard, os.Stderr, false)
+func uploadToContainer(ctx context.Context, bin io.Reader, config *config.Config, clientset *k8s.Clientset) error {
+	newCmd := func(dataDir string) []string {
+		return []string{"tar", "-C", dataDir, "-xzvf", "-"}
+	}
+
+	if config.GetString("ephemeral-container") != "" {
+		// the files extracted by the ephemeral container are owned by its
+		// user. they are handed over to the owner of the data directory, so
+		// that Prometheus can write to them.
+		newCmd = func(dataDir string) []string {
+			script := fmt.Sprintf(`tar -C %[1]s -xzf - && chown -R "$(stat -c %%u:%%g %[1]s)" %[1]s`, dataDir)
+			return []string{"sh", "-c", script}
+		}
+	}
+
+	return execDataDir(ctx, config, clientset, newCmd, bin, io.Discard)
 }
 
-func dumpSamples(config *config.Config, clientset *k8s.Clientset) error {
-	dataDir := config.GetString("data-dir")
-	maxTime, err := time.Parse(timeFormat, config.GetString("max-time"))
+func dumpSamples(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	// the time range is resolved to RFC3339 times during validation
+	maxTime, err := time.Parse(time.RFC3339Nano, config.GetString("max-time"))
+	if err != nil {
+		return err
+	}
+	minTime, err := time.Parse(time.RFC3339Nano, config.GetString("min-time"))
+	if err != nil {
+		return err
+	}
+
+	execCmd := dumpCommand(config, minTime, maxTime, config.GetString("head-chunks-repair"))
+
+	if !needsRewrite(config) {
+		return execDump(ctx, config, clientset, execCmd, os.Stdout)
+	}
+
+	// buffer the data dump in a temporary file so that it can be rewritten
+	dumpFile, err := os.CreateTemp("", "promdump-*.tar.gz")
 	if err != nil {
 		return err
 	}
-	minTime, err := time.Parse(timeFormat, config.GetString("min-time"))
+	defer func() {
+		_ = dumpFile.Close()
+		_ = os.Remove(dumpFile.Name())
+	}()
+
+	if err := execDump(ctx, config, clientset, execCmd, dumpFile); err != nil {
+		return err
+	}
+
+	if _, err := dumpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return rewriteDump(dumpFile, os.Stdout, config)
+}
+
+// execDump runs the dump command, and writes the data dump to w. Only the
+// gzipped archive is written to w. The dump is incomplete if fewer bytes are
+// received than the promdump binary reports to have written.
+func execDump(ctx context.Context, config *config.Config, clientset *k8s.Clientset, execCmd []string, w io.Writer) error {
+	reporter := newProgress(config, "dump")
+	if reporter != nil {
+		execCmd = append(execCmd, "-progress")
+		w = reporter.Writer(w)
+		defer reporter.Done()
+	}
+
+	guard := archive.NewGuard(w)
+	frame, err := execCoreStatus(ctx, config, clientset, execCmd, nil, guard, reporter)
 	if err != nil {
 		return err
 	}
-	maxTimestamp := strconv.FormatInt(maxTime.UnixNano(), 10)
-	minTimestamp := strconv.FormatInt(minTime.UnixNano(), 10)
 
-	execCmd := []string{fmt.Sprintf("%s/promdump", dataDir),
-		"-min-time", minTimestamp,
-		"-max-time", maxTimestamp,
-		"-data-dir", dataDir}
-	if config.GetBool("debug") {
-		execCmd = append(execCmd, "-debug")
+	if err := guard.Close(); err != nil {
+		return err
 	}
 
-	return clientset.ExecPod(execCmd, os.Stdin, os.Stdout, os.Stderr, false)
+	if received := guard.Written(); received != frame.Bytes {
+		return fmt.Errorf("%w: received %d of %d bytes", status.ErrIncompleteArchive, received, frame.Bytes)
+	}
+
+	return nil
+}
+
+// dumpCommand returns the command that runs the promdump binary to dump the
+// data between minTime and maxTime.
+func dumpCommand(config *config.Config, minTime, maxTime time.Time, headChunksRepair string) []string {
+	return coreCommand(config,
+		"-min-time", strconv.FormatInt(minTime.UnixNano(), 10),
+		"-max-time", strconv.FormatInt(maxTime.UnixNano(), 10),
+		"-head-chunks-repair", headChunksRepair)
 }
 
-func clean(config *config.Config, clientset *k8s.Clientset) error {
-	dataDir := config.GetString("data-dir")
-	execCmd := []string{"rm", "-f", fmt.Sprintf("%s/promdump", dataDir)}
-	return clientset.ExecPod(execCmd, os.Stdin, os.Stdout, os.Stderr, false)
+func clean(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	if config.GetString("pvc") != "" {
+		// the helper pod is deleted afterwards
+		return nil
+	}
+
+	// nothing but the data dump is written to stdout
+	return clientset.Retry(ctx, func() error {
+		if config.GetString("via") == k8s.ViaEphemeral {
+			execCmd := []string{"rm", "-f", path.Join(tmpBinDir, "promdump"), path.Join(tmpBinDir, corePIDFile)}
+			return clientset.ExecContainer(ctx, config.GetString("ephemeral-container"), execCmd, nil, io.Discard, os.Stderr, false)
+		}
+
+		newCmd := func(dataDir string) []string {
+			return []string{"rm", "-f", fmt.Sprintf("%s/promdump", dataDir), path.Join(dataDir, corePIDFile)}
+		}
+		return execDataDir(ctx, config, clientset, newCmd, nil, io.Discard)
+	})
 }
 
 func initLogger() {
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+)
+
+// startSnapshotHelperPod takes a volume snapshot of the Prometheus persistent
+// volume claim, provisions a temporary claim from it and starts a helper pod
+// which mounts the temporary claim. The persistent volume claim is the one
+// specified with --pvc, or the one mounted at the data directory of the
+// Prometheus pod. The returned function deletes all the created objects, with
+// a new context, so that they're deleted even if ctx is done.
+func startSnapshotHelperPod(ctx context.Context, config *config.Config, clientset *k8s.Clientset) (func(), error) {
+	var (
+		claim   = config.GetString("pvc")
+		subPath = config.GetString("pvc-sub-path")
+		cleanup []func(context.Context) error
+	)
+
+	runCleanup := func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+
+		for i := len(cleanup) - 1; i >= 0; i-- {
+			if err := cleanup[i](cleanupCtx); err != nil {
+				_ = level.Warn(logger).Log("message", "failed to clean up", "reason", err)
+			}
+		}
+	}
+
+	if claim == "" {
+		var err error
+		claim, subPath, err = clientset.DataDirClaim(config.GetString("data-dir"))
+		if err != nil {
+			return nil, fmt.Errorf("can't find persistent volume claim of pod: %w", err)
+		}
+	}
+
+	snapshot, err := clientset.CreateVolumeSnapshot(ctx, claim, config.GetString("snapshot-class"))
+	if err != nil {
+		return nil, fmt.Errorf("can't take volume snapshot: %w", err)
+	}
+	cleanup = append(cleanup, func(ctx context.Context) error {
+		return clientset.DeleteVolumeSnapshot(ctx, snapshot)
+	})
+
+	snapshotClaim, err := clientset.CreateClaimFromSnapshot(ctx, claim, snapshot)
+	if err != nil {
+		runCleanup()
+		return nil, fmt.Errorf("can't provision persistent volume claim from volume snapshot: %w", err)
+	}
+	cleanup = append(cleanup, func(ctx context.Context) error {
+		return clientset.DeleteClaim(ctx, snapshotClaim)
+	})
+
+	// the helper pod mounts the claim provisioned from the snapshot
+	config.Set("pvc", snapshotClaim)
+	config.Set("pvc-sub-path", subPath)
+
+	deleteHelperPod, err := startHelperPod(ctx, config, clientset)
+	if err != nil {
+		runCleanup()
+		return nil, err
+	}
+	cleanup = append(cleanup, func(context.Context) error {
+		deleteHelperPod()
+		return nil
+	})
+
+	return runCleanup, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s"
+	"github.com/ihcsim/promdump/pkg/progress"
+	"github.com/ihcsim/promdump/pkg/status"
+)
+
+const (
+	// tmpBinDir is where the promdump binary is copied to, in the ephemeral
+	// container and the helper pod.
+	tmpBinDir = "/tmp"
+
+	// ephemeralLifetime is how long the ephemeral container runs for. Ephemeral
+	// containers can't be removed from a pod, so it exits on its own.
+	ephemeralLifetime = time.Hour
+
+	// cleanupTimeout is how long the clean-up of the promdump binary may take,
+	// after the operation is cancelled or timed out.
+	cleanupTimeout = 30 * time.Second
+
+	// corePIDFile is the file which the promdump binary writes its process ID
+	// to, next to the binary.
+	corePIDFile = "promdump.pid"
+)
+
+// startHelperPod starts a helper pod which mounts the Prometheus persistent
+// volume claim read-only, at the data directory. Subsequent exec requests are
+// sent to the helper pod. The returned function deletes the helper pod, with a
+// new context, so that it's deleted even if ctx is done.
+func startHelperPod(ctx context.Context, config *config.Config, clientset *k8s.Clientset) (func(), error) {
+	pod, err := clientset.StartHelperPod(ctx, config.GetString("pvc"), config.GetString("ephemeral-image"), config.GetString("data-dir"))
+	if err != nil {
+		return nil, fmt.Errorf("can't start helper pod: %w", err)
+	}
+
+	config.Set("pod", pod)
+	config.Set("container", k8s.HelperContainer)
+
+	return func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+
+		if err := clientset.DeletePod(cleanupCtx, pod); err != nil {
+			_ = level.Warn(logger).Log("message", "failed to delete helper pod", "pod", pod, "reason", err)
+		}
+	}, nil
+}
+
+// prepareTransfer determines how files are copied to and removed from the data
+// directory. If tar isn't available in the Prometheus container (e.g. in
+// distroless images), or if the promdump binary runs in an ephemeral container
+// (--via ephemeral), an ephemeral container is attached to the pod. It shares
+// the process namespace of the Prometheus container and accesses the data
+// directory through /proc/<pid>/root.
+func prepareTransfer(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	if config.GetString("ephemeral-container") != "" {
+		return nil
+	}
+
+	if config.GetString("via") != k8s.ViaEphemeral {
+		ok, err := hasTar(ctx, clientset)
+		if err != nil {
+			return fmt.Errorf("can't check for tar in the Prometheus container: %w", err)
+		}
+		if ok {
+			return nil
+		}
+
+		_ = level.Info(logger).Log("message", "tar not found in the Prometheus container; using an ephemeral container",
+			"image", config.GetString("ephemeral-image"))
+	}
+
+	sleep := []string{"sleep", strconv.Itoa(int(ephemeralLifetime.Seconds()))}
+	name, err := clientset.StartEphemeralContainer(ctx, config.GetString("ephemeral-image"), sleep)
+	if err != nil {
+		return fmt.Errorf("can't start ephemeral container: %w", err)
+	}
+
+	config.Set("ephemeral-container", name)
+	return nil
+}
+
+// hasTar returns true if tar can extract archives in the Prometheus container.
+// It returns false if tar is missing, and any other failure of the exec
+// request as an error, e.g. a denied request or an unreachable pod.
+func hasTar(ctx context.Context, clientset *k8s.Clientset) (bool, error) {
+	execCmd := []string{"tar", "-tzf", "-"}
+	err := clientset.Retry(ctx, func() error {
+		return clientset.ExecPod(ctx, execCmd, bytes.NewReader(emptyArchive()), io.Discard, io.Discard, false)
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case k8s.IsCommandNotFound(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func emptyArchive() []byte {
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	_ = tar.NewWriter(gzipWriter).Close()
+	_ = gzipWriter.Close()
+	return buf.Bytes()
+}
+
+// execDataDir runs the command returned by newCmd against the data directory.
+// The command runs in the ephemeral container if one was attached by
+// prepareTransfer, or in the Prometheus container otherwise. newCmd receives
+// the path of the data directory, as seen by the command.
+func execDataDir(ctx context.Context, config *config.Config, clientset *k8s.Clientset, newCmd func(dataDir string) []string, stdin io.Reader, stdout io.Writer) error {
+	container := config.GetString("ephemeral-container")
+	if container == "" {
+		return clientset.ExecPod(ctx, newCmd(config.GetString("data-dir")), stdin, stdout, os.Stderr, false)
+	}
+
+	return clientset.ExecContainer(ctx, container, newCmd(procDataDir(config)), stdin, stdout, os.Stderr, false)
+}
+
+// procDataDir returns the path of the data directory, as seen by the ephemeral
+// container.
+func procDataDir(config *config.Config) string {
+	return path.Join(fmt.Sprintf("/proc/%d/root", k8s.TargetPID), config.GetString("data-dir"))
+}
+
+// uploadCore copies the promdump binary to where it runs. With --via
+// ephemeral, it's copied to the ephemeral container. With --pvc, it's copied
+// to the helper pod, whose data directory is read-only. Otherwise, it's copied
+// to the data directory of the Prometheus container. The binary is built for
+// the platform of the node of the pod.
+func uploadCore(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	promdumpBin, err := coreArchive(ctx, config, clientset)
+	if err != nil {
+		return err
+	}
+
+	execCmd := []string{"tar", "-C", tmpBinDir, "-xzf", "-"}
+	return clientset.Retry(ctx, func() error {
+		switch {
+		case config.GetString("via") == k8s.ViaEphemeral:
+			return clientset.ExecContainer(ctx, config.GetString("ephemeral-container"), execCmd, bytes.NewReader(promdumpBin), io.Discard, os.Stderr, false)
+		case config.GetString("pvc") != "":
+			return clientset.ExecPod(ctx, execCmd, bytes.NewReader(promdumpBin), io.Discard, os.Stderr, false)
+		default:
+			return uploadToContainer(ctx, bytes.NewReader(promdumpBin), config, clientset)
+		}
+	})
+}
+
+// coreCommand returns the command that runs the promdump binary with args,
+// against the data directory.
+func coreCommand(config *config.Config, args ...string) []string {
+	var (
+		binDir  = config.GetString("data-dir")
+		dataDir = config.GetString("data-dir")
+	)
+	switch {
+	case config.GetString("via") == k8s.ViaEphemeral:
+		binDir = tmpBinDir
+		dataDir = procDataDir(config)
+	case config.GetString("pvc") != "":
+		binDir = tmpBinDir
+		dataDir = path.Join(dataDir, config.GetString("pvc-sub-path"))
+	}
+
+	execCmd := append([]string{path.Join(binDir, "promdump")}, args...)
+	execCmd = append(execCmd, "-data-dir", dataDir, "-pid-file", path.Join(binDir, corePIDFile))
+	if config.GetBool("debug") {
+		execCmd = append(execCmd, "-debug")
+	}
+
+	return execCmd
+}
+
+// execCore runs the command returned by coreCommand, streaming its output to
+// stdout.
+func execCore(ctx context.Context, config *config.Config, clientset *k8s.Clientset, command []string, stdin io.Reader, stdout io.Writer) error {
+	_, err := execCoreStatus(ctx, config, clientset, command, stdin, stdout, nil)
+	return err
+}
+
+// execCoreStatus runs the command returned by coreCommand, and returns the
+// final status frame of the promdump binary. The status frames are removed
+// from its stderr. The progress frames are reported to reporter, if it isn't
+// nil. A non-zero exit code is returned as *status.Error.
+func execCoreStatus(ctx context.Context, config *config.Config, clientset *k8s.Clientset, command []string, stdin io.Reader, stdout io.Writer, reporter *progress.Reporter) (*status.Frame, error) {
+	stderr := status.NewFilter(os.Stderr)
+	if reporter != nil {
+		stderr = stderr.WithProgress(reporter.Update)
+	}
+
+	var err error
+	if config.GetString("via") == k8s.ViaEphemeral {
+		err = clientset.ExecContainer(ctx, config.GetString("ephemeral-container"), command, stdin, stdout, stderr, false)
+	} else {
+		err = clientset.ExecPod(ctx, command, stdin, stdout, stderr, false)
+	}
+	_ = stderr.Flush()
+
+	frame := stderr.Frame()
+	if err := status.Check(err, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// newProgress returns a reporter of the progress of a transfer to stderr, or
+// nil if the progress isn't reported, e.g. because stderr isn't a terminal.
+func newProgress(config *config.Config, label string) *progress.Reporter {
+	if !config.GetBool("progress") || !progress.IsTerminal(os.Stderr) {
+		return nil
+	}
+
+	return progress.New(os.Stderr, label)
+}
+
+// cleanupCore removes the promdump binary. If ctx is done (e.g. on interrupt
+// or timeout), the remote promdump process is killed first, as closing the
+// exec stream doesn't stop it. A new context is used, so that the clean-up
+// always runs.
+func cleanupCore(ctx context.Context, config *config.Config, clientset *k8s.Clientset) {
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		if err := killCore(cleanupCtx, config, clientset); err != nil {
+			_ = level.Warn(logger).Log("message", "failed to stop promdump", "reason", err)
+		}
+	}
+
+	if err := clean(cleanupCtx, config, clientset); err != nil {
+		_ = level.Warn(logger).Log("message", "failed to remove promdump", "reason", err)
+	}
+}
+
+// killCore kills the remote promdump process, whose ID is read from its pid
+// file, as pkill is missing from many images. If an ephemeral container is
+// attached, the process is killed from there, as the ephemeral container
+// shares the process namespace of the Prometheus container.
+func killCore(ctx context.Context, config *config.Config, clientset *k8s.Clientset) error {
+	execCmd := []string{"sh", "-c", `kill "$(cat "$1")"`, "sh", corePIDPath(config)}
+	return clientset.Retry(ctx, func() error {
+		if container := config.GetString("ephemeral-container"); container != "" {
+			return clientset.ExecContainer(ctx, container, execCmd, nil, io.Discard, os.Stderr, false)
+		}
+
+		return clientset.ExecPod(ctx, execCmd, nil, io.Discard, os.Stderr, false)
+	})
+}
+
+// corePIDPath returns the path of the pid file of the promdump binary, as
+// seen by the container which kills it. If the binary runs in the Prometheus
+// container, but an ephemeral container is attached because tar is missing,
+// the pid file is read through /proc/<pid>/root.
+func corePIDPath(config *config.Config) string {
+	if config.GetString("ephemeral-container") != "" && config.GetString("via") != k8s.ViaEphemeral {
+		return path.Join(procDataDir(config), corePIDFile)
+	}
+
+	return path.Join(path.Dir(coreCommand(config)[0]), corePIDFile)
+}
@@ -4,43 +4,66 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/ihcsim/promdump/pkg/status"
+	"github.com/ihcsim/promdump/pkg/timerange"
 	"github.com/ihcsim/promdump/pkg/tsdb"
 	promtsdb "github.com/prometheus/prometheus/tsdb"
 )
 
 const (
-	defaultLogLevel = "error"
+	defaultLogLevel = "warn"
+
+	metaFormatJSON = "json"
+	metaFormatText = "text"
 
 	timeFormatFile = "2006-01-02-150405"
 	timeFormatOut  = "2006-01-02 15:04:05"
+
+	// progressInterval is the min interval between two progress frames.
+	progressInterval = time.Second
+
+	// headBlock is the block name of the head chunk files and the WAL in the
+	// progress frames.
+	headBlock = "head"
 )
 
 var (
-	logger                *log.Logger
-	msgNoHeadBlock        = "No head block found"
-	msgNoPersistentBlocks = "No persistent blocks found"
-	targetDir             = os.TempDir()
+	logger                  *log.Logger
+	msgNoHeadBlock          = "No head block found"
+	msgNoPersistentBlocks   = "No persistent blocks found"
+	errIntegrityCheckFailed = status.ErrIntegrityCheckFailed
+	errIncompleteArchive    = status.ErrIncompleteArchive
+	errInvalidArgs          = status.ErrInvalidArgs
+	targetDir               = os.TempDir()
 )
 
 func main() {
 	var (
-		defaultMaxTime = time.Now().UTC()
-		defaultMinTime = defaultMaxTime.Add(-2 * time.Hour)
-
 		dataDir  = flag.String("data-dir", "/data", "path to the Prometheus data directory")
-		minTime  = flag.Int64("min-time", defaultMinTime.UnixNano(), "lower bound of the timestamp range (in nanoseconds)")
-		maxTime  = flag.Int64("max-time", defaultMaxTime.UnixNano(), "upper bound of the timestamp range (in nanoseconds)")
+		minTime  = flag.String("min-time", "now-2h", "lower bound of the timestamp range: an RFC3339 time, a Unix timestamp (e.g. in nanoseconds), or a relative time (e.g. now-2h)")
+		maxTime  = flag.String("max-time", timerange.Now, "upper bound of the timestamp range, in the formats of -min-time")
+		all      = flag.Bool("all", false, "dump the entire TSDB, including the samples after now, instead of the min and max times")
 		debug    = flag.Bool("debug", false, "run promdump in debug mode")
 		showMeta = flag.Bool("meta", false, "retrieve the Promtheus TSDB metadata")
+		check    = flag.Bool("check", false, "verify the integrity of the Prometheus TSDB")
+		alert    = flag.String("alert", "", "list the incidents of this alert between the min and max times, as JSON, instead of dumping the data")
+		format   = flag.String("meta-format", metaFormatText, "output format of the metadata (text|json)")
+		repair   = flag.String("head-chunks-repair", tsdb.RepairDrop, "how to handle out-of-sequence head chunk files (drop|renumber|none)")
+		progress = flag.Bool("progress", false, "report the progress of the dump on stderr, with status frames")
+		pidFile  = flag.String("pid-file", "", "path of the file to write the process ID to, so that the process can be stopped without pkill")
 		help     = flag.Bool("help", false, "show usage")
 	)
 	flag.Parse()
@@ -56,8 +79,27 @@ func main() {
 	}
 	logger = log.New(logLevel, os.Stderr)
 
-	if err := validateTimestamp(*minTime, *maxTime); err != nil {
-		exit(err)
+	if *pidFile != "" {
+		if err := os.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			exit(fmt.Errorf("can't write pid file: %w", err))
+		}
+	}
+
+	timeRange, err := parseTimeRange(*minTime, *maxTime, time.Now())
+	if err != nil {
+		exit(fmt.Errorf("%w: %s", errInvalidArgs, err))
+	}
+
+	if *all {
+		timeRange = timerange.Range{Min: time.Unix(0, 0), Max: time.Unix(0, math.MaxInt64)}
+	}
+
+	if err := tsdb.ValidateRepairStrategy(*repair); err != nil {
+		exit(fmt.Errorf("%w: %s", errInvalidArgs, err))
+	}
+
+	if *format != metaFormatText && *format != metaFormatJSON {
+		exit(fmt.Errorf("%w: unsupported metadata format: %s", errInvalidArgs, *format))
 	}
 
 	tsdb, err := tsdb.New(*dataDir, logger)
@@ -72,24 +114,84 @@ func main() {
 			exit(err)
 		}
 
-		if _, err := writeMeta(headMeta, blockMeta); err != nil {
+		if *format == metaFormatJSON {
+			if err := writeMetaJSON(headMeta, blockMeta); err != nil {
+				exit(err)
+			}
+			done(0)
+			return
+		}
+
+		nbr, err := writeMeta(headMeta, blockMeta)
+		if err != nil {
+			exit(err)
+		}
+
+		done(nbr)
+		return
+	}
+
+	if *check {
+		results, err := tsdb.Check()
+		if err != nil {
+			exit(err)
+		}
+
+		if err := writeCheckResults(results, os.Stdout); err != nil {
 			exit(err)
 		}
 
+		done(0)
 		return
 	}
 
-	blocks, err := tsdb.Blocks(*minTime, *maxTime)
+	if *alert != "" {
+		incidents, err := tsdb.AlertIncidents(*alert, timeRange.Min.UnixNano(), timeRange.Max.UnixNano())
+		if err != nil {
+			exit(err)
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(incidents); err != nil {
+			exit(err)
+		}
+
+		done(0)
+		return
+	}
+
+	blocks, err := tsdb.Blocks(timeRange.Min.UnixNano(), timeRange.Max.UnixNano())
+	if err != nil {
+		exit(err)
+	}
+
+	headChunksRepair, err := tsdb.PlanHeadChunksRepair(*repair)
 	if err != nil {
 		exit(err)
 	}
 
-	nbr, err := writeBlocks(*dataDir, blocks, os.Stdout)
+	if len(headChunksRepair.Dropped) > 0 {
+		_ = level.Warn(logger.Logger).Log("message", "dropping out-of-sequence head chunk files",
+			"numFiles", len(headChunksRepair.Dropped),
+			"numBytesLost", headChunksRepair.LostBytes())
+	}
+
+	if len(headChunksRepair.Renamed) > 0 {
+		_ = level.Warn(logger.Logger).Log("message", "renumbering out-of-sequence head chunk files",
+			"numFiles", len(headChunksRepair.Renamed))
+	}
+
+	var dumpProgress *progressFrames
+	if *progress {
+		dumpProgress = &progressFrames{w: os.Stderr}
+	}
+
+	nbr, err := writeBlocks(*dataDir, blocks, headChunksRepair, dumpProgress, os.Stdout)
 	if err != nil {
 		exit(err)
 	}
 
 	_ = level.Info(logger.Logger).Log("message", "operation completed", "numBytesRead", nbr)
+	done(nbr)
 }
 
 func writeMeta(headMeta *tsdb.HeadMeta, blockMeta *tsdb.BlockMeta) (int64, error) {
@@ -140,46 +242,105 @@ Total size              | %d
 	return buf.WriteTo(os.Stdout)
 }
 
-func writeBlocks(dataDir string, blocks []*promtsdb.Block, w io.Writer) (int64, error) {
+func writeMetaJSON(headMeta *tsdb.HeadMeta, blockMeta *tsdb.BlockMeta) error {
+	meta := &tsdb.Metadata{Head: headMeta, Blocks: blockMeta}
+	return json.NewEncoder(os.Stdout).Encode(meta)
+}
+
+func writeCheckResults(results []tsdb.CheckResult, w io.Writer) error {
+	for _, result := range results {
+		if _, err := fmt.Fprintln(w, result); err != nil {
+			return err
+		}
+	}
+
+	if tsdb.CheckFailed(results) {
+		return errIntegrityCheckFailed
+	}
+
+	return nil
+}
+
+func writeBlocks(dataDir string, blocks []*promtsdb.Block, repair *tsdb.HeadChunksRepair, progress *progressFrames, w io.Writer) (int64, error) {
 	if len(blocks) == 0 {
-		buf := bytes.NewBuffer([]byte(msgNoPersistentBlocks))
-		return buf.WriteTo(os.Stdout)
+		// the head block and WAL are still dumped. the message goes to stderr,
+		// so that the dump remains a valid archive.
+		_ = level.Warn(logger.Logger).Log("message", msgNoPersistentBlocks)
 	}
 
 	pipeReader, pipeWriter := io.Pipe()
 	defer pipeReader.Close()
 
 	go func() {
-		defer pipeWriter.Close()
-		if err := compressed(dataDir, blocks, pipeWriter); err != nil {
-			_ = level.Error(logger.Logger).Log("message", "error closing pipeWriter", "reason", err)
+		// the error is returned by the read side of the pipe, so that the
+		// process exits with a non-zero code
+		if err := compressed(dataDir, blocks, repair, progress, pipeWriter); err != nil {
+			_ = pipeWriter.CloseWithError(fmt.Errorf("%w: %s", errIncompleteArchive, err))
+			return
 		}
+		_ = pipeWriter.Close()
 	}()
 
 	return io.Copy(w, pipeReader)
 }
 
-func compressed(dataDir string, blocks []*promtsdb.Block, writer *io.PipeWriter) error {
+// compressed writes the gzipped TAR archive of the head block, the WAL and the
+// persistent blocks to writer. The files are streamed through the TAR and gzip
+// writers as they are read, so that the archive isn't held in memory. If
+// progress isn't nil, the progress of the files read is reported to it.
+func compressed(dataDir string, blocks []*promtsdb.Block, repair *tsdb.HeadChunksRepair, progress *progressFrames, writer io.Writer) error {
 	var (
-		buf = &bytes.Buffer{}
-		tw  = tar.NewWriter(buf)
+		now      = time.Now()
+		filename = fmt.Sprintf(filepath.Join(targetDir, "promdump-%s.tar.gz"), now.Format(timeFormatFile))
+		gwriter  = gzip.NewWriter(writer)
+		tw       = tar.NewWriter(gwriter)
+		dropped  = map[string]struct{}{}
 	)
 
+	gwriter.Header = gzip.Header{
+		Name:    filename,
+		ModTime: now,
+		OS:      255,
+	}
+
+	for _, file := range repair.Dropped {
+		dropped[file.Path] = struct{}{}
+	}
+
 	dirs := []string{
 		filepath.Join(dataDir, "chunks_head"),
 		filepath.Join(dataDir, "wal"),
 	}
+	blockIDs := map[string]string{
+		dirs[0]: headBlock,
+		dirs[1]: headBlock,
+	}
 	for _, block := range blocks {
 		dirs = append(dirs, block.Dir())
+		blockIDs[block.Dir()] = block.Meta().ULID.String()
 	}
 
+	progress.count(dirs, dropped)
+
 	// walk all the block directories
 	for _, dir := range dirs {
 		if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				// e.g. WAL segments removed by a checkpoint, or head chunk
+				// files removed by a compaction, while the dump is running
+				_ = level.Warn(logger.Logger).Log("message", "skipping missing file", "path", path)
+				return nil
+			}
+
 			if err != nil {
 				return err
 			}
 
+			if _, ok := dropped[path]; ok {
+				_ = level.Debug(logger.Logger).Log("message", "skipping out-of-sequence head chunk file", "path", path)
+				return nil
+			}
+
 			var link string
 			if info.Mode()&os.ModeSymlink == os.ModeSymlink {
 				if link, err = os.Readlink(path); err != nil {
@@ -193,27 +354,21 @@ func compressed(dataDir string, blocks []*promtsdb.Block, writer *io.PipeWriter)
 			}
 
 			header.Name = path[len(dataDir)+1:]
-			if err = tw.WriteHeader(header); err != nil {
-				return err
+			if name, ok := repair.Renamed[path]; ok {
+				// the source file is left untouched; only its name in the
+				// dump is changed
+				header.Name = filepath.Join(filepath.Dir(header.Name), name)
 			}
 
 			if !info.Mode().IsRegular() {
-				return nil
-			}
-
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("failed to read data file: %w", err)
+				return tw.WriteHeader(header)
 			}
 
-			buf := bytes.NewBuffer(data)
-			if _, err := io.Copy(tw, buf); err != nil {
-				return fmt.Errorf("failed to write compressed file: %w", err)
-			}
-
-			return nil
+			return writeFile(tw, path, header, func(size int64) {
+				progress.file(blockIDs[dir], size)
+			})
 		}); err != nil {
-			_ = level.Error(logger.Logger).Log("errors", err)
+			return err
 		}
 	}
 
@@ -221,43 +376,138 @@ func compressed(dataDir string, blocks []*promtsdb.Block, writer *io.PipeWriter)
 		return err
 	}
 
-	now := time.Now()
-	filename := fmt.Sprintf(filepath.Join(targetDir, "promdump-%s.tar.gz"), now.Format(timeFormatFile))
+	return gwriter.Close()
+}
 
-	gwriter := gzip.NewWriter(writer)
-	defer gwriter.Close()
+// writeFile streams the regular file at path to tw, with header. The size of
+// the file is read once it's opened, as it may have changed since the walk,
+// e.g. if it's the WAL segment being written to. Only that many bytes are
+// written. done is called with the size, once the file is written.
+func writeFile(tw *tar.Writer, path string, header *tar.Header, done func(size int64)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		_ = level.Warn(logger.Logger).Log("message", "skipping missing file", "path", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data file: %w", err)
+	}
+	defer file.Close()
 
-	gwriter.Header = gzip.Header{
-		Name:    filename,
-		ModTime: now,
-		OS:      255,
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read data file: %w", err)
 	}
 
-	if _, err := gwriter.Write(buf.Bytes()); err != nil {
+	header.Size = info.Size()
+	if err := tw.WriteHeader(header); err != nil {
 		return err
 	}
 
+	if _, err := io.CopyN(tw, file, header.Size); err != nil {
+		return fmt.Errorf("failed to write compressed file: %w", err)
+	}
+
+	done(header.Size)
 	return nil
 }
 
-func validateTimestamp(minTime, maxTime int64) error {
-	if minTime > maxTime {
-		return fmt.Errorf("min-time (%d) cannot exceed max-time (%d)", minTime, maxTime)
+// progressFrames writes the progress of a dump to w, as status frames. Its
+// methods are no-ops on a nil receiver, i.e. if progress isn't reported.
+type progressFrames struct {
+	w        io.Writer
+	progress status.Progress
+	reported time.Time
+}
+
+// count sets the total number of files and bytes to dump, found in dirs.
+func (p *progressFrames) count(dirs []string, skipped map[string]struct{}) {
+	if p == nil {
+		return
 	}
 
-	now := time.Now().UnixNano()
-	if minTime > now {
-		return fmt.Errorf("min-time (%d) cannot exceed now (%d)", minTime, now)
+	for _, dir := range dirs {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			// unreadable files are reported by the dump itself
+			if err != nil {
+				return nil
+			}
+
+			if _, ok := skipped[path]; ok || !info.Mode().IsRegular() {
+				return nil
+			}
+
+			p.progress.TotalFiles++
+			p.progress.TotalBytes += info.Size()
+			return nil
+		})
 	}
+}
 
-	if maxTime > now {
-		return fmt.Errorf("max-time (%d) cannot exceed now (%d)", maxTime, now)
+// file reports that a file of size bytes of block was dumped. The frames are
+// written at most every progressInterval, except for the last file.
+func (p *progressFrames) file(block string, size int64) {
+	if p == nil {
+		return
 	}
 
-	return nil
+	p.progress.Files++
+	p.progress.Bytes += size
+	p.progress.Block = block
+
+	now := time.Now()
+	if now.Sub(p.reported) < progressInterval && p.progress.Files < p.progress.TotalFiles {
+		return
+	}
+	p.reported = now
+
+	progress := p.progress
+	_ = status.Write(p.w, status.Frame{Code: status.CodeOK, Progress: &progress})
+}
+
+// parseTimeRange parses the time expressions of the min and max times, and
+// validates their range.
+func parseTimeRange(minTime, maxTime string, now time.Time) (timerange.Range, error) {
+	var (
+		r   timerange.Range
+		err error
+	)
+	if r.Min, err = timerange.Parse(minTime, now, time.UTC); err != nil {
+		return r, fmt.Errorf("invalid min-time: %w", err)
+	}
+
+	if r.Max, err = timerange.Parse(maxTime, now, time.UTC); err != nil {
+		return r, fmt.Errorf("invalid max-time: %w", err)
+	}
+
+	return r, timerange.Validate(r, now)
+}
+
+// done writes the final status frame of a successful operation to stderr.
+// nbr is the number of bytes written to stdout.
+func done(nbr int64) {
+	_ = status.Write(os.Stderr, status.Frame{
+		Code:    status.CodeOK,
+		Message: "operation completed",
+		Bytes:   nbr,
+	})
 }
 
+// exit writes the final status frame of the failed operation to stderr, and
+// exits with the code of err.
 func exit(err error) {
 	_ = level.Error(logger.Logger).Log("error", err)
-	os.Exit(1)
+
+	code := status.CodeFailure
+	switch {
+	case errors.Is(err, errInvalidArgs):
+		code = status.CodeInvalidArgs
+	case errors.Is(err, errIntegrityCheckFailed):
+		code = status.CodeIntegrityCheckFailed
+	case errors.Is(err, errIncompleteArchive):
+		code = status.CodeIncompleteArchive
+	}
+
+	_ = status.Write(os.Stderr, status.Frame{Code: code, Message: err.Error()})
+	os.Exit(code)
 }
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errIllegalPath = fmt.Errorf("illegal file path in archive")
+	errNotGzip     = fmt.Errorf("output isn't a gzip stream")
+)
+
+// Extract decompresses the gzipped TAR stream r, and writes its content to the
+// dir directory. The directory will be created if it doesn't exist.
+func Extract(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("can't read gzip stream: %w", err)
+	}
+	defer gr.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can't read tar stream: %w", err)
+		}
+
+		// guard against entries that escape the target directory
+		path := filepath.Join(dir, header.Name)
+		if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%w: %s", errIllegalPath, header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write file %s: %w", path, err)
+			}
+
+			if err := file.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Compress walks the dir directory, and writes its content to w as a gzipped
+// TAR stream. The names of the TAR entries are relative to dir.
+func Compress(dir string, w io.Writer) error {
+	var (
+		gw = gzip.NewWriter(w)
+		tw = tar.NewWriter(gw)
+	)
+
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to read data file: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(tw, file); err != nil {
+			return fmt.Errorf("failed to write compressed file: %w", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// gzipMagic is the header of gzip streams.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Guard is an io.Writer which only lets gzip streams through to the
+// underlying writer, so that no other text ends up in a data dump. It also
+// counts the bytes written.
+type Guard struct {
+	w      io.Writer
+	header []byte
+	n      int64
+}
+
+// NewGuard returns a new Guard which writes to w.
+func NewGuard(w io.Writer) *Guard {
+	return &Guard{w: w}
+}
+
+// Write writes p to the underlying writer, once the gzip header is verified.
+func (g *Guard) Write(p []byte) (int, error) {
+	written := len(p)
+	if len(g.header) < len(gzipMagic) {
+		i := len(gzipMagic) - len(g.header)
+		if i > len(p) {
+			i = len(p)
+		}
+		g.header = append(g.header, p[:i]...)
+		if !bytes.HasPrefix(gzipMagic, g.header) {
+			return 0, errNotGzip
+		}
+
+		if len(g.header) < len(gzipMagic) {
+			return written, nil
+		}
+
+		// the buffered header is written with the rest of p
+		p = append(append([]byte{}, g.header...), p[i:]...)
+	}
+
+	n, err := g.w.Write(p)
+	g.n += int64(n)
+	if err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// Close returns an error if nothing, or an incomplete gzip header, was
+// written.
+func (g *Guard) Close() error {
+	if len(g.header) < len(gzipMagic) {
+		return errNotGzip
+	}
+	return nil
+}
+
+// Written returns the number of bytes written to the underlying writer.
+func (g *Guard) Written() int64 {
+	return g.n
+}
@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "promdump-archive-src")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	files := map[string][]byte{
+		filepath.Join("chunks_head", "000001"):                   []byte("head chunks"),
+		filepath.Join("wal", "00000001"):                         []byte("wal segment"),
+		filepath.Join("01F5ETH5T4MKTXJ1PEHQ71758P", "meta.json"): []byte("{}"),
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Compress(srcDir, buf); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	dstDir, err := os.MkdirTemp("", "promdump-archive-dst")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	if err := Extract(buf, dstDir); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	for name, expected := range files {
+		actual, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("mismatch content of %s. expected: %s, actual: %s", name, expected, actual)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		writes   [][]byte
+		expected error
+	}{
+		{
+			name:   "gzip",
+			writes: [][]byte{{0x1f, 0x8b, 0x08}, []byte("data")},
+		},
+		{
+			name:   "split header",
+			writes: [][]byte{{0x1f}, {0x8b}, []byte("data")},
+		},
+		{
+			name:     "text",
+			writes:   [][]byte{[]byte("No persistent blocks found")},
+			expected: errNotGzip,
+		},
+		{
+			name:     "empty",
+			expected: errNotGzip,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				buf      = &bytes.Buffer{}
+				guard    = NewGuard(buf)
+				expected []byte
+				err      error
+			)
+			for _, p := range tc.writes {
+				if _, err = guard.Write(p); err != nil {
+					break
+				}
+				expected = append(expected, p...)
+			}
+			if err == nil {
+				err = guard.Close()
+			}
+
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors. expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if tc.expected != nil {
+				if buf.Len() != 0 {
+					t.Errorf("expected nothing to be written, actual: %q", buf.String())
+				}
+				return
+			}
+
+			if !bytes.Equal(expected, buf.Bytes()) {
+				t.Errorf("mismatch output. expected: %q, actual: %q", expected, buf.Bytes())
+			}
+
+			if actual := guard.Written(); actual != int64(len(expected)) {
+				t.Errorf("mismatch written bytes. expected: %d, actual: %d", len(expected), actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix prefixes the environment variables which override the defaults of
+// the profile flags, e.g. PROMDUMP_DATA_DIR overrides the default of
+// --data-dir.
+const EnvPrefix = "PROMDUMP_"
+
+// TimeWindowKey is the name of the environment variable of the time window.
+const TimeWindowKey = EnvPrefix + "TIME_WINDOW"
+
+// ProfileFlags are the flags whose defaults are set by the profiles and the
+// environment variables. The other flags, e.g. the safety flags of restore,
+// can only be set explicitly.
+var ProfileFlags = []string{
+	"context",
+	"namespace",
+	"pod-selector",
+	"container",
+	"data-dir",
+	"relabel-config",
+}
+
+// ErrUnknownProfile is returned when the selected profile isn't defined in the
+// config file.
+var ErrUnknownProfile = fmt.Errorf("unknown profile")
+
+// File is the content of the promdump config file.
+type File struct {
+	// CurrentProfile is the profile used when no profile is selected.
+	CurrentProfile string `json:"currentProfile,omitempty"`
+
+	// Profiles are the named profiles, e.g. one per cluster.
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+}
+
+// Profile is a named set of defaults of the flags. Flags which are set
+// explicitly, or with environment variables, take precedence.
+type Profile struct {
+	Context     string `json:"context,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	PodSelector string `json:"podSelector,omitempty"`
+	Container   string `json:"container,omitempty"`
+	DataDir     string `json:"dataDir,omitempty"`
+
+	// TimeWindow is the default duration of the dumped time range, ending
+	// now, e.g. 6h.
+	TimeWindow string `json:"timeWindow,omitempty"`
+
+	// RedactionPolicy is the path to a YAML file with Prometheus
+	// relabel_configs, which redact the dumped series.
+	RedactionPolicy string `json:"redactionPolicy,omitempty"`
+}
+
+// DefaultPath returns the path of the config file, in the promdump directory
+// of $XDG_CONFIG_HOME, or of ~/.config.
+func DefaultPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "promdump", "config.yaml"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't determine home directory: %w", err)
+	}
+
+	return filepath.Join(home, ".config", "promdump", "config.yaml"), nil
+}
+
+// Load reads the config file at path. A missing file is an empty config.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, fmt.Errorf("can't parse config file %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// Profile returns the profile name, or the current profile if name is empty.
+// It returns a nil profile if no profile is selected.
+func (f *File) Profile(name string) (string, *Profile, error) {
+	if name == "" {
+		name = f.CurrentProfile
+	}
+
+	if name == "" {
+		return "", nil, nil
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok || profile == nil {
+		return "", nil, fmt.Errorf("%w: %q. defined profiles: [%s]", ErrUnknownProfile, name, strings.Join(f.profileNames(), ", "))
+	}
+
+	return name, profile, nil
+}
+
+func (f *File) profileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Flags returns the values of the profile, keyed by the names of their
+// flags. The time window doesn't map to a flag.
+func (p *Profile) Flags() map[string]string {
+	flags := map[string]string{
+		"context":        p.Context,
+		"namespace":      p.Namespace,
+		"pod-selector":   p.PodSelector,
+		"container":      p.Container,
+		"data-dir":       p.DataDir,
+		"relabel-config": p.RedactionPolicy,
+	}
+
+	for name, value := range flags {
+		if value == "" {
+			delete(flags, name)
+		}
+	}
+
+	return flags
+}
+
+// EnvKey returns the environment variable which overrides the flag name.
+func EnvKey(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Lookup returns the value of the flag name, if it's set explicitly, or the
+// value of its environment variable.
+func Lookup(flags *pflag.FlagSet, name string) string {
+	if flag := flags.Lookup(name); flag != nil && flag.Changed {
+		return flag.Value.String()
+	}
+
+	return os.Getenv(EnvKey(name))
+}
+
+// Apply sets the profile flags which aren't set explicitly, to the values of
+// their environment variables, or of the profile. profile can be nil.
+func Apply(flags *pflag.FlagSet, profile *Profile) error {
+	var defaults map[string]string
+	if profile != nil {
+		defaults = profile.Flags()
+	}
+
+	for _, name := range ProfileFlags {
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+
+		key := EnvKey(name)
+		if value, ok := os.LookupEnv(key); ok {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+			continue
+		}
+
+		if value, ok := defaults[name]; ok {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value of profile option %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TimeWindow returns the time window of the TimeWindowKey environment
+// variable, or of the profile. profile can be nil.
+func TimeWindow(profile *Profile) string {
+	if value, ok := os.LookupEnv(TimeWindowKey); ok {
+		return value
+	}
+
+	if profile == nil {
+		return ""
+	}
+	return profile.TimeWindow
+}
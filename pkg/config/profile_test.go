@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+const testConfigFile = `currentProfile: prod-us
+profiles:
+  prod-eu:
+    context: prod-eu
+    namespace: monitoring
+    podSelector: app.kubernetes.io/name=prometheus
+    container: prometheus-server
+    dataDir: /prometheus
+    timeWindow: 6h
+    redactionPolicy: /etc/promdump/redact.yaml
+  prod-us:
+    context: prod-us
+    namespace: observability
+`
+
+func TestLoad(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "promdump-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	file, err := Load(path)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	var testCases = []struct {
+		name              string
+		profile           string
+		expectedName      string
+		expectedNamespace string
+		expectErr         error
+	}{
+		{name: "current profile", expectedName: "prod-us", expectedNamespace: "observability"},
+		{name: "selected profile", profile: "prod-eu", expectedName: "prod-eu", expectedNamespace: "monitoring"},
+		{name: "unknown profile", profile: "staging", expectErr: ErrUnknownProfile},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, profile, err := file.Profile(tc.profile)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("mismatch errors. expected: %v, actual: %v", tc.expectErr, err)
+			}
+			if tc.expectErr != nil {
+				return
+			}
+
+			if name != tc.expectedName || profile.Namespace != tc.expectedNamespace {
+				t.Errorf("mismatch profile. expected: %s (namespace: %s), actual: %s (namespace: %s)", tc.expectedName, tc.expectedNamespace, name, profile.Namespace)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		file, err := Load(filepath.Join(tempDir, "missing.yaml"))
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if _, profile, err := file.Profile(""); err != nil || profile != nil {
+			t.Errorf("expected no profile, actual: %+v (error: %v)", profile, err)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("profiles:\n  prod:\n    namespaces: monitoring\n"), 0600); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if _, err := Load(path); err == nil {
+			t.Error("expected error of unknown field")
+		}
+	})
+}
+
+func TestApply(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("namespace", "default", "")
+	flags.String("container", "prometheus", "")
+	flags.String("data-dir", "/data", "")
+	flags.String("context", "", "")
+	flags.String("relabel-config", "", "")
+	flags.Bool("yes", false, "")
+	flags.StringSlice("deny-context", nil, "")
+
+	profile := &Profile{
+		Context:         "prod-eu",
+		Namespace:       "monitoring",
+		Container:       "prometheus-server",
+		DataDir:         "/prometheus",
+		RedactionPolicy: "/etc/promdump/redact.yaml",
+	}
+
+	// flags take precedence over environment variables, which take
+	// precedence over the profile
+	if err := flags.Parse([]string{"--namespace", "flag-ns"}); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	t.Setenv("PROMDUMP_NAMESPACE", "env-ns")
+	t.Setenv("PROMDUMP_DATA_DIR", "/env")
+
+	// the other flags can't be set by environment variables
+	t.Setenv("PROMDUMP_YES", "true")
+	t.Setenv("PROMDUMP_DENY_CONTEXT", "prod-*")
+
+	if err := Apply(flags, profile); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	expected := map[string]string{
+		"namespace":      "flag-ns",
+		"container":      "prometheus-server",
+		"data-dir":       "/env",
+		"context":        "prod-eu",
+		"relabel-config": "/etc/promdump/redact.yaml",
+	}
+	for name, value := range expected {
+		if actual, err := flags.GetString(name); err != nil || actual != value {
+			t.Errorf("mismatch flag %s. expected: %s, actual: %s (error: %v)", name, value, actual, err)
+		}
+	}
+
+	for _, name := range []string{"yes", "deny-context"} {
+		if flags.Lookup(name).Changed {
+			t.Errorf("unexpected override of flag %s: %s", name, flags.Lookup(name).Value)
+		}
+	}
+
+	profile.TimeWindow = "6h"
+	if actual := TimeWindow(profile); actual != "6h" {
+		t.Errorf("mismatch time window. expected: 6h, actual: %s", actual)
+	}
+
+	t.Setenv(TimeWindowKey, "2h")
+	if actual := TimeWindow(profile); actual != "2h" {
+		t.Errorf("mismatch time window. expected: 2h, actual: %s", actual)
+	}
+}
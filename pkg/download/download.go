@@ -1,50 +1,171 @@
 package download
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/ihcsim/promdump/pkg/log"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-var errChecksumMismatch = fmt.Errorf("mismatch checksum")
+const (
+	// partialSuffix is the suffix of the files being downloaded. They are
+	// renamed once they are complete, and resumed otherwise.
+	partialSuffix = ".part"
+
+	// maxChecksumSize is the maximum size of a checksum file.
+	maxChecksumSize = 4096
+
+	retryFactor = 2.0
+	retryJitter = 0.1
+)
+
+var (
+	errChecksumMismatch = fmt.Errorf("mismatch checksum")
+	errTooLarge         = fmt.Errorf("download exceeds maximum size")
+)
+
+// ProgressFunc is called with the number of bytes of a file downloaded so far,
+// including the resumed ones, and its total size. The total size is -1 if it's
+// unknown.
+type ProgressFunc func(written, total int64)
 
 // Download can issue GET requests to download assets from a remote endpoint.
 type Download struct {
-	logger   *log.Logger
-	http     *http.Client
-	localDir string
+	logger    *log.Logger
+	http      *http.Client
+	localDir  string
+	publicKey *PublicKey
+
+	maxSize          int64
+	progress         ProgressFunc
+	retries          int
+	retryInterval    time.Duration
+	retryMaxInterval time.Duration
 }
 
-// New returns a new instance of Download.
+// New returns a new instance of Download. It uses the proxy of the
+// environment.
 func New(localDir string, timeout time.Duration, logger *log.Logger) *Download {
 	return &Download{
 		localDir: localDir,
 		logger:   logger,
 		http: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
 		},
 	}
 }
 
+// WithTransport configures the proxy and the trusted CA certificates of the
+// requests. An empty proxyURL uses the proxy of the environment. The
+// certificates of the PEM file caFile are trusted in addition to the system
+// ones.
+func (d *Download) WithTransport(proxyURL, caFile string) (*Download, error) {
+	transport := d.http.Transport.(*http.Transport)
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", caFile)
+		}
+
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return d, nil
+}
+
+// WithRetry retries the requests which fail with transient errors up to
+// retries times. The interval between retries starts at interval, and doubles
+// after every retry, up to maxInterval.
+func (d *Download) WithRetry(retries int, interval, maxInterval time.Duration) *Download {
+	d.retries = retries
+	d.retryInterval = interval
+	d.retryMaxInterval = maxInterval
+	return d
+}
+
+// WithMaxSize fails the downloads of files larger than maxSize bytes. Zero
+// means no limit.
+func (d *Download) WithMaxSize(maxSize int64) *Download {
+	d.maxSize = maxSize
+	return d
+}
+
+// WithProgress reports the progress of the downloads to fn.
+func (d *Download) WithProgress(fn ProgressFunc) *Download {
+	d.progress = fn
+	return d
+}
+
+// WithPublicKey sets the public key used to verify the signatures of the
+// downloaded files. The signature of a file is downloaded from the same
+// endpoint, with the SignatureSuffix suffix.
+func (d *Download) WithPublicKey(key *PublicKey) *Download {
+	d.publicKey = key
+	return d
+}
+
+// CoreArchive returns the name of the gzipped TAR file of the promdump binary
+// of version, built for the goos/goarch platform.
+func CoreArchive(version, goos, goarch string) string {
+	return fmt.Sprintf("promdump-%s-%s-%s.tar.gz", goos, goarch, version)
+}
+
+// GetCore downloads the promdump binary of version, built for the goos/goarch
+// platform, from the release of version at baseURL. It's verified with the
+// SHA256 sum file published next to it. See Get.
+func (d *Download) GetCore(ctx context.Context, baseURL, version, goos, goarch string) (io.ReadCloser, error) {
+	remoteURI := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(baseURL, "/"), version, CoreArchive(version, goos, goarch))
+	return d.Get(ctx, false, remoteURI, remoteURI+".sha256")
+}
+
 // Get issues a GET request to the remote endpoint to download the promdump TAR
 // file, to the localDir directory. If non-empty, it also fetches the SHA256 sum
 // file from the specifed endpoint, and used that to verified the content of the
 // downloaded TAR file.
 // If the file is already present on the local file system, then the download will
 // be skipped, unless force is set to true to trigger a re-download.
+// If a public key is set, the signature of the file is also verified. A cached
+// file which fails the verification is removed and re-downloaded.
 // The content of the file is then read and returned. Caller is responsible for
-// closing the returned ReadCloser.
-func (d *Download) Get(force bool, remoteURI, remoteURISHA string) (io.ReadCloser, error) {
+// closing the returned ReadCloser. The requests and the retries are cancelled
+// when ctx is done.
+func (d *Download) Get(ctx context.Context, force bool, remoteURI, remoteURISHA string) (io.ReadCloser, error) {
 	var (
 		exists    = true
 		filename  = path.Base(remoteURI)
@@ -62,10 +183,21 @@ func (d *Download) Get(force bool, remoteURI, remoteURISHA string) (io.ReadClose
 	}
 
 	if exists && !force {
-		return tarFile, nil
+		err := d.verify(ctx, tarFile, remoteURI, savedPath)
+		if err == nil {
+			return tarFile, nil
+		}
+
+		_ = level.Warn(d.logger).Log("message", "invalidating cached file",
+			"path", savedPath,
+			"reason", err)
+		_ = tarFile.Close()
+		d.invalidate(savedPath)
+	} else if exists {
+		_ = tarFile.Close()
 	}
 
-	if err := d.download(remoteURI, savedPath); err != nil {
+	if err := d.download(ctx, remoteURI, savedPath); err != nil {
 		return nil, err
 	}
 
@@ -74,57 +206,297 @@ func (d *Download) Get(force bool, remoteURI, remoteURISHA string) (io.ReadClose
 		return nil, err
 	}
 
-	if err := d.checksum(newFile, remoteURISHA); err != nil {
+	if err := d.checksum(ctx, newFile, remoteURISHA); err != nil {
+		// don't let the next call use the corrupted file
+		_ = newFile.Close()
+		d.invalidate(savedPath)
+		return nil, err
+	}
+
+	if err := d.verify(ctx, newFile, remoteURI, savedPath); err != nil {
+		_ = newFile.Close()
+		d.invalidate(savedPath)
 		return nil, err
 	}
 
 	return newFile, nil
 }
 
-func (d *Download) download(remote, savedPath string) error {
+// verify verifies the signature of file with the public key. The signature is
+// cached next to the file at savedPath. It's a no-op if there is no public
+// key.
+func (d *Download) verify(ctx context.Context, file *os.File, remoteURI, savedPath string) error {
+	if d.publicKey == nil {
+		return nil
+	}
+
+	sigPath := savedPath + SignatureSuffix
+	if _, err := os.Stat(sigPath); os.IsNotExist(err) {
+		if err := d.download(ctx, remoteURI+SignatureSuffix, sigPath); err != nil {
+			return fmt.Errorf("can't download signature: %w", err)
+		}
+	}
+
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return err
+	}
+
+	_ = level.Info(d.logger).Log("message", "verifying signature",
+		"path", file.Name(),
+		"keyID", d.publicKey)
+	if err := d.publicKey.Verify(file, sig); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_ = level.Info(d.logger).Log("message", "confirmed signature")
+	return nil
+}
+
+// invalidate removes the cached file at savedPath, and its signature.
+func (d *Download) invalidate(savedPath string) {
+	for _, path := range []string{savedPath, savedPath + SignatureSuffix} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			_ = level.Warn(d.logger).Log("message", "can't remove cached file",
+				"path", path,
+				"reason", err)
+		}
+	}
+}
+
+// download downloads remote to savedPath. The content is written to a partial
+// file, which is renamed to savedPath once it's complete, so that savedPath is
+// never left incomplete. Transient failures are retried, and resume from the
+// end of the partial file, until ctx is done.
+func (d *Download) download(ctx context.Context, remote, savedPath string) error {
 	_ = level.Info(d.logger).Log("message", "downloading promdump",
 		"remoteURI", remote,
 		"timeout", d.http.Timeout,
 		"localDir", savedPath)
 
-	resp, err := d.http.Get(remote)
+	var (
+		partialPath = savedPath + partialSuffix
+		backoff     = wait.Backoff{
+			Duration: d.retryInterval,
+			Factor:   retryFactor,
+			Jitter:   retryJitter,
+			Steps:    d.retries,
+			Cap:      d.retryMaxInterval,
+		}
+	)
+
+	for attempt := 0; ; attempt++ {
+		nbr, err := d.fetch(ctx, remote, partialPath)
+		if err == nil {
+			if err := os.Rename(partialPath, savedPath); err != nil {
+				return err
+			}
+
+			_ = level.Info(d.logger).Log("message", "download completed", "numBytesWrite", nbr)
+			return nil
+		}
+
+		// the partial file of a transient failure is kept, so that the
+		// next attempt resumes from it
+		transient := isTransient(err)
+		if !transient {
+			_ = os.Remove(partialPath)
+		}
+
+		if attempt >= d.retries || !transient {
+			return err
+		}
+
+		interval := backoff.Step()
+		_ = level.Warn(d.logger).Log("message", "retrying download after transient failure",
+			"attempt", attempt+1,
+			"retries", d.retries,
+			"interval", interval,
+			"reason", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("download cancelled after %d attempts: %w", attempt+1, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// fetch appends the content of remote to the partial file at partialPath. If
+// the partial file isn't empty, only the remaining range is requested. It
+// returns the size of the partial file.
+func (d *Download) fetch(ctx context.Context, remote, partialPath string) (int64, error) {
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed. reason: %s", resp.Status)
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
 	}
 
-	file, err := os.Create(savedPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remote, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 
-	nbr, err := io.CopyN(file, resp.Body, resp.ContentLength)
+	resp, err := d.http.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	_ = level.Info(d.logger).Log("message", "download completed", "numBytesWrite", nbr)
-	return nil
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return 0, fmt.Errorf("download failed. reason: unexpected content range %q", resp.Header.Get("Content-Range"))
+		}
+
+		_ = level.Info(d.logger).Log("message", "resuming download", "offset", offset)
+	case resp.StatusCode == http.StatusOK:
+		// the server doesn't support ranges; start over
+		if offset, err = 0, file.Truncate(0); err != nil {
+			return 0, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is stale; start over on retry
+		if err := file.Truncate(0); err != nil {
+			return 0, err
+		}
+		return 0, &statusError{code: resp.StatusCode, status: resp.Status}
+	default:
+		return 0, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	if d.maxSize > 0 && total > d.maxSize {
+		return 0, fmt.Errorf("%w: %d bytes exceeds %d bytes", errTooLarge, total, d.maxSize)
+	}
+
+	var (
+		body io.Reader = resp.Body
+		w    io.Writer = file
+	)
+	if d.maxSize > 0 {
+		// read one byte past the limit, to detect bodies without length
+		// which exceed it
+		body = io.LimitReader(resp.Body, d.maxSize-offset+1)
+	}
+	if d.progress != nil {
+		w = &progressWriter{w: file, written: offset, total: total, fn: d.progress}
+	}
+
+	nbr, err := io.Copy(w, body)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.maxSize > 0 && offset+nbr > d.maxSize {
+		return 0, fmt.Errorf("%w: more than %d bytes", errTooLarge, d.maxSize)
+	}
+
+	if resp.ContentLength >= 0 && nbr != resp.ContentLength {
+		return 0, fmt.Errorf("download failed after %d of %d bytes: %w", nbr, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+
+	return offset + nbr, file.Close()
 }
 
-func (d *Download) checksum(file *os.File, remote string) error {
+// statusError is a non-successful HTTP response.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("download failed. reason: %s", e.status)
+}
+
+// isTransient returns true if err is a transient failure of a request, like a
+// reset connection, a timeout or a 5xx response, after which the request can
+// be retried. A cancelled request isn't transient.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 ||
+			statusErr.code == http.StatusTooManyRequests ||
+			statusErr.code == http.StatusRequestTimeout ||
+			statusErr.code == http.StatusRequestedRangeNotSatisfiable
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// progressWriter reports the number of bytes written to fn.
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	total   int64
+	fn      ProgressFunc
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	p.fn(p.written, p.total)
+	return n, err
+}
+
+func (d *Download) checksum(ctx context.Context, file *os.File, remote string) error {
 	_ = level.Info(d.logger).Log("message", "verifying checksum",
 		"endpoint", remote,
 		"timeout", d.http.Timeout,
 	)
 
-	resp, err := d.http.Get(remote)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remote, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.http.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	buf := &bytes.Buffer{}
-	if _, err := io.CopyN(buf, resp.Body, resp.ContentLength); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumSize))
+	if err != nil {
 		return err
 	}
 
@@ -137,7 +509,12 @@ func (d *Download) checksum(file *os.File, remote string) error {
 	}
 
 	actual := fmt.Sprintf("%x", sha.Sum(nil))
-	expected := strings.TrimSpace(buf.String())
+	// the checksum may be followed by the file name, as in the output of
+	// sha256sum
+	expected := ""
+	if fields := strings.Fields(string(data)); len(fields) > 0 {
+		expected = fields[0]
+	}
 	_ = level.Debug(d.logger).Log("message", "comparing checksum",
 		"expected", expected,
 		"actual", actual)
@@ -1,13 +1,21 @@
 package download
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,7 +66,7 @@ func TestDownload(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	d := New(tempDir, timeout, logger)
-	reader, err := d.Get(force, remoteURI, remoteURISHA)
+	reader, err := d.Get(context.Background(), force, remoteURI, remoteURISHA)
 	if err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
@@ -73,3 +81,454 @@ func TestDownload(t *testing.T) {
 		t.Errorf("mismatch response. expected:%s, actual:%s", downloadContent, actual)
 	}
 }
+
+func TestGetCore(t *testing.T) {
+	var (
+		content = []byte("test promdump archive")
+		logger  = log.New("debug", io.Discard)
+		timeout = time.Second
+		version = "v0.1.0"
+		archive = CoreArchive(version, "linux", "arm64")
+	)
+
+	var testCases = []struct {
+		name      string
+		checksum  []byte
+		expectErr bool
+	}{
+		{name: "checksum match", checksum: content},
+		{name: "checksum mismatch", checksum: []byte("corrupted"), expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle(fmt.Sprintf("/%s/%s", version, archive), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				if _, err := resp.Write(content); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+			}))
+			mux.Handle(fmt.Sprintf("/%s/%s.sha256", version, archive), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				if _, err := fmt.Fprintf(resp, "%x\n", sha256.Sum256(tc.checksum)); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+			}))
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			tempDir, err := os.MkdirTemp("", "promdump-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			d := New(tempDir, timeout, logger)
+			reader, err := d.GetCore(context.Background(), server.URL+"/", version, "linux", "arm64")
+			if tc.expectErr {
+				if !errors.Is(err, errChecksumMismatch) {
+					t.Fatalf("expected error: %v, actual: %v", errChecksumMismatch, err)
+				}
+
+				// the corrupted archive must not be cached
+				if _, err := os.Stat(filepath.Join(tempDir, archive)); !os.IsNotExist(err) {
+					t.Errorf("expected corrupted archive to be removed, actual: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer reader.Close()
+
+			actual, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if !reflect.DeepEqual(actual, content) {
+				t.Errorf("mismatch response. expected:%s, actual:%s", content, actual)
+			}
+		})
+	}
+}
+
+func TestGetSignature(t *testing.T) {
+	var (
+		content = []byte("test promdump archive")
+		logger  = log.New("debug", io.Discard)
+		timeout = time.Second
+		version = "v0.1.0"
+		archive = CoreArchive(version, "linux", "amd64")
+
+		publicKey, privateKey = testKeys(t, 0x1234)
+		_, otherKey           = testKeys(t, 0x1234)
+	)
+
+	newServer := func(signingKey ed25519.PrivateKey, numDownloads *int) *httptest.Server {
+		mux := http.NewServeMux()
+		mux.Handle(fmt.Sprintf("/%s/%s", version, archive), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			*numDownloads++
+			if _, err := resp.Write(content); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}))
+		mux.Handle(fmt.Sprintf("/%s/%s.sha256", version, archive), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if _, err := fmt.Fprintf(resp, "%x\n", sha256.Sum256(content)); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}))
+		mux.Handle(fmt.Sprintf("/%s/%s%s", version, archive, SignatureSuffix), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if _, err := resp.Write(testSign(t, signingKey, 0x1234, sigAlgPrehashed, content, "file:"+archive)); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}))
+		return httptest.NewServer(mux)
+	}
+
+	t.Run("invalidate tampered cache", func(t *testing.T) {
+		var numDownloads int
+		server := newServer(privateKey, &numDownloads)
+		defer server.Close()
+
+		tempDir, err := os.MkdirTemp("", "promdump-test")
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		d := New(tempDir, timeout, logger).WithPublicKey(publicKey)
+		get := func() []byte {
+			reader, err := d.GetCore(context.Background(), server.URL, version, "linux", "amd64")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer reader.Close()
+
+			actual, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			return actual
+		}
+
+		if actual := get(); !reflect.DeepEqual(actual, content) {
+			t.Errorf("mismatch response. expected:%s, actual:%s", content, actual)
+		}
+
+		// the verified cache is reused
+		if actual := get(); !reflect.DeepEqual(actual, content) {
+			t.Errorf("mismatch response. expected:%s, actual:%s", content, actual)
+		}
+		if numDownloads != 1 {
+			t.Errorf("expected 1 download, actual: %d", numDownloads)
+		}
+
+		// the tampered cache is re-downloaded
+		if err := os.WriteFile(filepath.Join(tempDir, archive), []byte("tampered"), 0644); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		if actual := get(); !reflect.DeepEqual(actual, content) {
+			t.Errorf("mismatch response. expected:%s, actual:%s", content, actual)
+		}
+		if numDownloads != 2 {
+			t.Errorf("expected 2 downloads, actual: %d", numDownloads)
+		}
+	})
+
+	t.Run("signature mismatch", func(t *testing.T) {
+		var numDownloads int
+		server := newServer(otherKey, &numDownloads)
+		defer server.Close()
+
+		tempDir, err := os.MkdirTemp("", "promdump-test")
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		d := New(tempDir, timeout, logger).WithPublicKey(publicKey)
+		if _, err := d.GetCore(context.Background(), server.URL, version, "linux", "amd64"); !errors.Is(err, errSignatureMismatch) {
+			t.Fatalf("expected error: %v, actual: %v", errSignatureMismatch, err)
+		}
+
+		for _, path := range []string{archive, archive + SignatureSuffix} {
+			if _, err := os.Stat(filepath.Join(tempDir, path)); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed, actual: %v", path, err)
+			}
+		}
+	})
+}
+
+func TestDownloadRobust(t *testing.T) {
+	var (
+		content = []byte(strings.Repeat("test promdump archive ", 100))
+		logger  = log.New("debug", io.Discard)
+		timeout = 5 * time.Second
+	)
+
+	var testCases = []struct {
+		name    string
+		handler func(attempt int) http.HandlerFunc
+		maxSize int64
+
+		expectErr      error
+		expectStatus   int
+		expectAttempts int
+	}{
+		{
+			name: "unknown content length",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					// flushing before the body is complete forces a chunked
+					// response without content length
+					for _, chunk := range [][]byte{content[:10], content[10:]} {
+						if _, err := resp.Write(chunk); err != nil {
+							t.Fatal("unexpected error: ", err)
+						}
+						resp.(http.Flusher).Flush()
+					}
+				}
+			},
+			expectAttempts: 1,
+		},
+		{
+			name: "retry server error",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					if attempt < 3 {
+						resp.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					http.ServeContent(resp, req, "", time.Time{}, bytes.NewReader(content))
+				}
+			},
+			expectAttempts: 3,
+		},
+		{
+			name: "resume interrupted download",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					if attempt == 1 {
+						// the connection is closed after half of the content
+						resp.Header().Set("Content-Length", strconv.Itoa(len(content)))
+						if _, err := resp.Write(content[:len(content)/2]); err != nil {
+							t.Fatal("unexpected error: ", err)
+						}
+						return
+					}
+
+					if expected := fmt.Sprintf("bytes=%d-", len(content)/2); req.Header.Get("Range") != expected {
+						t.Errorf("mismatch range. expected: %q, actual: %q", expected, req.Header.Get("Range"))
+					}
+					http.ServeContent(resp, req, "", time.Time{}, bytes.NewReader(content))
+				}
+			},
+			expectAttempts: 2,
+		},
+		{
+			name: "not found",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					resp.WriteHeader(http.StatusNotFound)
+				}
+			},
+			expectStatus:   http.StatusNotFound,
+			expectAttempts: 1,
+		},
+		{
+			name: "max size",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					http.ServeContent(resp, req, "", time.Time{}, bytes.NewReader(content))
+				}
+			},
+			maxSize:        int64(len(content) - 1),
+			expectErr:      errTooLarge,
+			expectAttempts: 1,
+		},
+		{
+			name: "max size of unknown content length",
+			handler: func(attempt int) http.HandlerFunc {
+				return func(resp http.ResponseWriter, req *http.Request) {
+					for _, chunk := range [][]byte{content[:10], content[10:]} {
+						if _, err := resp.Write(chunk); err != nil {
+							return
+						}
+						resp.(http.Flusher).Flush()
+					}
+				}
+			},
+			maxSize:        int64(len(content) - 1),
+			expectErr:      errTooLarge,
+			expectAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				attempts++
+				tc.handler(attempts)(resp, req)
+			}))
+			defer server.Close()
+
+			tempDir, err := os.MkdirTemp("", "promdump-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			var written, total int64
+			d := New(tempDir, timeout, logger).
+				WithRetry(3, time.Millisecond, 10*time.Millisecond).
+				WithMaxSize(tc.maxSize).
+				WithProgress(func(w, t int64) { written, total = w, t })
+
+			savedPath := filepath.Join(tempDir, "promdump.tar.gz")
+			err = d.download(context.Background(), server.URL, savedPath)
+			if attempts != tc.expectAttempts {
+				t.Errorf("mismatch attempts. expected: %d, actual: %d", tc.expectAttempts, attempts)
+			}
+
+			if tc.expectStatus != 0 {
+				var statusErr *statusError
+				if !errors.As(err, &statusErr) || statusErr.code != tc.expectStatus {
+					t.Errorf("expected status: %d, actual: %v", tc.expectStatus, err)
+				}
+			}
+
+			if tc.expectErr != nil || tc.expectStatus != 0 {
+				if tc.expectErr != nil && !errors.Is(err, tc.expectErr) {
+					t.Errorf("expected error: %v, actual: %v", tc.expectErr, err)
+				}
+
+				// neither the incomplete file nor the partial file are left
+				for _, path := range []string{savedPath, savedPath + partialSuffix} {
+					if _, err := os.Stat(path); !os.IsNotExist(err) {
+						t.Errorf("expected %s to be removed, actual: %v", path, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			actual, err := os.ReadFile(savedPath)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			if !bytes.Equal(actual, content) {
+				t.Errorf("mismatch content. expected: %d bytes, actual: %d bytes", len(content), len(actual))
+			}
+
+			if written != int64(len(content)) || (total != -1 && total != written) {
+				t.Errorf("mismatch progress. expected: %d bytes, actual: %d of %d bytes", len(content), written, total)
+			}
+		})
+	}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "promdump-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// the retry interval is longer than the test timeout, so the download
+	// only returns early if the backoff is cancelled
+	d := New(tempDir, 5*time.Second, log.New("debug", io.Discard)).
+		WithRetry(3, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = d.download(ctx, server.URL, filepath.Join(tempDir, "promdump.tar.gz"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error: %v, actual: %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the download to be cancelled, actual duration: %s", elapsed)
+	}
+}
+
+func TestDownloadTransport(t *testing.T) {
+	var (
+		content = []byte("test promdump archive")
+		logger  = log.New("debug", io.Discard)
+		timeout = 5 * time.Second
+	)
+
+	tempDir, err := os.MkdirTemp("", "promdump-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	t.Run("CA file", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if _, err := resp.Write(content); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}))
+		defer server.Close()
+
+		// the self-signed certificate of the server isn't trusted by default
+		savedPath := filepath.Join(tempDir, "untrusted.tar.gz")
+		if err := New(tempDir, timeout, logger).download(context.Background(), server.URL, savedPath); err == nil {
+			t.Fatal("expected certificate error")
+		}
+
+		caFile := filepath.Join(tempDir, "ca.pem")
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := os.WriteFile(caFile, cert, 0644); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		d, err := New(tempDir, timeout, logger).WithTransport("", caFile)
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		savedPath = filepath.Join(tempDir, "trusted.tar.gz")
+		if err := d.download(context.Background(), server.URL, savedPath); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		var proxied string
+		proxy := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			// requests to a proxy have absolute URLs
+			proxied = req.URL.String()
+			if _, err := resp.Write(content); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}))
+		defer proxy.Close()
+
+		d, err := New(tempDir, timeout, logger).WithTransport(proxy.URL, "")
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		remote := "http://releases.promdump.test/promdump.tar.gz"
+		if err := d.download(context.Background(), remote, filepath.Join(tempDir, "proxied.tar.gz")); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if proxied != remote {
+			t.Errorf("mismatch proxied request. expected: %s, actual: %s", remote, proxied)
+		}
+	})
+}
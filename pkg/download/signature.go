@@ -0,0 +1,166 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureSuffix is the suffix of the minisign signature files of the
+// downloaded assets.
+const SignatureSuffix = ".minisig"
+
+const (
+	// the minisign signature algorithms. the legacy algorithm signs the
+	// content, while the default one signs its BLAKE2b-512 hash.
+	sigAlgLegacy    = "Ed"
+	sigAlgPrehashed = "ED"
+
+	trustedCommentPrefix = "trusted comment: "
+)
+
+var (
+	errInvalidPublicKey  = fmt.Errorf("invalid public key")
+	errInvalidSignature  = fmt.Errorf("invalid signature")
+	errSignatureMismatch = fmt.Errorf("mismatch signature")
+)
+
+// PublicKey is a minisign ed25519 public key.
+type PublicKey struct {
+	ID  uint64
+	Key ed25519.PublicKey
+}
+
+// ParsePublicKey parses the minisign public key s. s is either the base64
+// encoded key, or the content of a minisign public key file.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	encoded := strings.TrimSpace(lines[len(lines)-1])
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidPublicKey, err)
+	}
+
+	if len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != sigAlgLegacy {
+		return nil, fmt.Errorf("%w: unsupported format", errInvalidPublicKey)
+	}
+
+	return &PublicKey{
+		ID:  binary.LittleEndian.Uint64(data[2:10]),
+		Key: ed25519.PublicKey(data[10:]),
+	}, nil
+}
+
+// String returns the hexadecimal key ID, as shown by minisign.
+func (k *PublicKey) String() string {
+	return fmt.Sprintf("%016X", k.ID)
+}
+
+// Verify verifies the minisign signature sig of the content of r.
+func (k *PublicKey) Verify(r io.Reader, sig []byte) error {
+	s, err := parseSignature(sig)
+	if err != nil {
+		return err
+	}
+
+	if s.keyID != k.ID {
+		return fmt.Errorf("%w: signed with key %016X, expected key %s", errSignatureMismatch, s.keyID, k)
+	}
+
+	var message []byte
+	switch s.algorithm {
+	case sigAlgPrehashed:
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		message = h.Sum(nil)
+	default:
+		if message, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+
+	if !ed25519.Verify(k.Key, message, s.signature) {
+		return fmt.Errorf("%w: content signature can't be verified with key %s", errSignatureMismatch, k)
+	}
+
+	// the global signature prevents the trusted comment from being tampered
+	// with
+	global := append(append([]byte{}, s.signature...), s.trustedComment...)
+	if !ed25519.Verify(k.Key, global, s.globalSignature) {
+		return fmt.Errorf("%w: trusted comment signature can't be verified with key %s", errSignatureMismatch, k)
+	}
+
+	return nil
+}
+
+type signature struct {
+	algorithm       string
+	keyID           uint64
+	signature       []byte
+	trustedComment  []byte
+	globalSignature []byte
+}
+
+// parseSignature parses the content of a minisign signature file, which is
+// made up of an untrusted comment, the signature, a trusted comment and the
+// global signature.
+func parseSignature(data []byte) (*signature, error) {
+	var (
+		lines   []string
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return nil, fmt.Errorf("%w: expected 4 lines in minisign format", errInvalidSignature)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidSignature, err)
+	}
+
+	if len(sig) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: unexpected length %d", errInvalidSignature, len(sig))
+	}
+
+	algorithm := string(sig[:2])
+	if algorithm != sigAlgLegacy && algorithm != sigAlgPrehashed {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errInvalidSignature, algorithm)
+	}
+
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidSignature, err)
+	}
+
+	if len(global) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: unexpected global signature length %d", errInvalidSignature, len(global))
+	}
+
+	return &signature{
+		algorithm:       algorithm,
+		keyID:           binary.LittleEndian.Uint64(sig[2:10]),
+		signature:       sig[10:],
+		trustedComment:  []byte(strings.TrimPrefix(lines[2], trustedCommentPrefix)),
+		globalSignature: global,
+	}, nil
+}
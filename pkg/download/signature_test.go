@@ -0,0 +1,158 @@
+package download
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestVerify(t *testing.T) {
+	var (
+		content        = []byte("test promdump archive")
+		trustedComment = "timestamp:1622246400\tfile:promdump-linux-amd64-v0.1.0.tar.gz"
+	)
+
+	publicKey, privateKey := testKeys(t, 0x1234)
+	otherKey, _ := testKeys(t, 0x1234)
+
+	var testCases = []struct {
+		name      string
+		key       *PublicKey
+		content   []byte
+		sig       []byte
+		expectErr error
+	}{
+		{
+			name:    "prehashed",
+			key:     publicKey,
+			content: content,
+			sig:     testSign(t, privateKey, 0x1234, sigAlgPrehashed, content, trustedComment),
+		},
+		{
+			name:    "legacy",
+			key:     publicKey,
+			content: content,
+			sig:     testSign(t, privateKey, 0x1234, sigAlgLegacy, content, trustedComment),
+		},
+		{
+			name:      "tampered content",
+			key:       publicKey,
+			content:   []byte("tampered promdump archive"),
+			sig:       testSign(t, privateKey, 0x1234, sigAlgPrehashed, content, trustedComment),
+			expectErr: errSignatureMismatch,
+		},
+		{
+			name:      "tampered trusted comment",
+			key:       publicKey,
+			content:   content,
+			sig:       bytes.Replace(testSign(t, privateKey, 0x1234, sigAlgPrehashed, content, trustedComment), []byte("v0.1.0"), []byte("v0.2.0"), 1),
+			expectErr: errSignatureMismatch,
+		},
+		{
+			name:      "different key",
+			key:       otherKey,
+			content:   content,
+			sig:       testSign(t, privateKey, 0x1234, sigAlgPrehashed, content, trustedComment),
+			expectErr: errSignatureMismatch,
+		},
+		{
+			name:      "different key ID",
+			key:       publicKey,
+			content:   content,
+			sig:       testSign(t, privateKey, 0x5678, sigAlgPrehashed, content, trustedComment),
+			expectErr: errSignatureMismatch,
+		},
+		{
+			name:      "malformed",
+			key:       publicKey,
+			content:   content,
+			sig:       []byte("untrusted comment: not a signature\n"),
+			expectErr: errInvalidSignature,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.key.Verify(bytes.NewReader(tc.content), tc.sig)
+			if tc.expectErr == nil {
+				if err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tc.expectErr) {
+				t.Errorf("expected error: %v, actual: %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	expected, _ := testKeys(t, 0xabcd)
+	keyFile := fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", expected, testEncodeKey(expected))
+
+	for _, s := range []string{testEncodeKey(expected), keyFile} {
+		actual, err := ParsePublicKey(s)
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if actual.ID != expected.ID || !actual.Key.Equal(expected.Key) {
+			t.Errorf("mismatch public key. expected: %s, actual: %s", expected, actual)
+		}
+	}
+
+	if _, err := ParsePublicKey("bm90IGEga2V5"); !errors.Is(err, errInvalidPublicKey) {
+		t.Errorf("expected error: %v, actual: %v", errInvalidPublicKey, err)
+	}
+}
+
+// testKeys generates a new key pair with the key ID id.
+func testKeys(t *testing.T, id uint64) (*PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	return &PublicKey{ID: id, Key: public}, private
+}
+
+// testEncodeKey returns the base64 encoded minisign public key.
+func testEncodeKey(key *PublicKey) string {
+	data := make([]byte, 10, 10+ed25519.PublicKeySize)
+	copy(data, sigAlgLegacy)
+	binary.LittleEndian.PutUint64(data[2:], key.ID)
+	data = append(data, key.Key...)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// testSign returns the minisign signature file of content.
+func testSign(t *testing.T, key ed25519.PrivateKey, id uint64, algorithm string, content []byte, trustedComment string) []byte {
+	message := content
+	if algorithm == sigAlgPrehashed {
+		h := blake2b.Sum512(content)
+		message = h[:]
+	}
+
+	sig := ed25519.Sign(key, message)
+	global := ed25519.Sign(key, append(append([]byte{}, sig...), trustedComment...))
+
+	data := make([]byte, 10, 10+ed25519.SignatureSize)
+	copy(data, algorithm)
+	binary.LittleEndian.PutUint64(data[2:], id)
+	data = append(data, sig...)
+
+	return []byte(fmt.Sprintf("untrusted comment: signature from test key\n%s\n%s%s\n%s\n",
+		base64.StdEncoding.EncodeToString(data),
+		trustedCommentPrefix,
+		trustedComment,
+		base64.StdEncoding.EncodeToString(global)))
+}
@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// ViaExec runs the promdump binary in the Prometheus container.
+	ViaExec = "exec"
+
+	// ViaEphemeral runs the promdump binary in an ephemeral container attached
+	// to the Prometheus pod. Nothing is written to the data directory.
+	ViaEphemeral = "ephemeral"
+
+	// EnvEphemeralImage is the environment variable that overrides the default
+	// image of the ephemeral container, e.g. to use a locally built image in
+	// tests.
+	EnvEphemeralImage = "PROMDUMP_EPHEMERAL_IMAGE"
+
+	defaultEphemeralImage = "busybox:1.33"
+)
+
+// TargetPID is the PID of the targeted container's process, as seen by an
+// ephemeral container sharing its process namespace. The targeted container's
+// filesystem is accessible through /proc/<TargetPID>/root.
+const TargetPID = 1
+
+var (
+	errEphemeralContainerTerminated = fmt.Errorf("ephemeral container terminated")
+	errUnsupportedVia               = fmt.Errorf("unsupported via mode")
+
+	// ephemeralPollInterval is the interval at which the pod is polled for the
+	// status of the ephemeral container.
+	ephemeralPollInterval = time.Second
+
+	// ephemeralStartTimeout is how long to wait for the ephemeral container to
+	// start, including the time it takes to pull its image.
+	ephemeralStartTimeout = 2 * time.Minute
+)
+
+// ValidateVia returns an error if via isn't a supported way to run the
+// promdump binary.
+func ValidateVia(via string) error {
+	switch via {
+	case ViaExec, ViaEphemeral:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedVia, via)
+	}
+}
+
+// EphemeralImage returns the default image of the ephemeral container. It can
+// be overridden with the PROMDUMP_EPHEMERAL_IMAGE environment variable.
+func EphemeralImage() string {
+	if image := os.Getenv(EnvEphemeralImage); image != "" {
+		return image
+	}
+	return defaultEphemeralImage
+}
+
+// StartEphemeralContainer attaches an ephemeral container running command with
+// image to the pod. The container shares the process namespace of the
+// Prometheus container. It returns the name of the ephemeral container, once
+// it's running. Ephemeral containers can't be removed from a pod; command
+// should exit on its own.
+func (c *Clientset) StartEphemeralContainer(ctx context.Context, image string, command []string) (string, error) {
+	var (
+		ns        = c.config.GetString("namespace")
+		pod       = c.config.GetString("pod")
+		container = c.config.GetString("container")
+		name      = "promdump-" + utilrand.String(5)
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, ephemeralStartTimeout)
+	defer cancel()
+
+	current, err := c.CoreV1().Pods(ns).Get(ctx, pod, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralContainer := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			Command:                  command,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			SecurityContext: &corev1.SecurityContext{
+				// needed to access the root filesystem of processes owned by
+				// other users
+				Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{"SYS_PTRACE"},
+				},
+			},
+		},
+		TargetContainerName: container,
+	}
+
+	ephemeralContainers := &corev1.EphemeralContainers{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            current.GetName(),
+			Namespace:       current.GetNamespace(),
+			ResourceVersion: current.GetResourceVersion(),
+		},
+		EphemeralContainers: append(current.Spec.EphemeralContainers, ephemeralContainer),
+	}
+
+	_ = level.Info(c.logger).Log("message", "starting ephemeral container",
+		"namespace", ns,
+		"pod", pod,
+		"container", name,
+		"image", image,
+		"target", container)
+
+	if _, err := c.CoreV1().Pods(ns).UpdateEphemeralContainers(ctx, pod, ephemeralContainers, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to add ephemeral container: %w", err)
+	}
+
+	if err := c.waitForEphemeralContainer(ctx, name); err != nil {
+		return "", fmt.Errorf("ephemeral container %s didn't start: %w", name, err)
+	}
+
+	return name, nil
+}
+
+func (c *Clientset) waitForEphemeralContainer(ctx context.Context, name string) error {
+	var (
+		ns  = c.config.GetString("namespace")
+		pod = c.config.GetString("pod")
+	)
+
+	return wait.PollImmediateUntil(ephemeralPollInterval, func() (bool, error) {
+		current, err := c.CoreV1().Pods(ns).Get(ctx, pod, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		for _, status := range current.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+
+			if status.State.Terminated != nil {
+				return false, fmt.Errorf("%w: %s", errEphemeralContainerTerminated, status.State.Terminated.Reason)
+			}
+
+			if status.State.Running != nil {
+				return true, nil
+			}
+		}
+
+		_ = level.Debug(c.logger).Log("message", "waiting for ephemeral container to start",
+			"namespace", ns,
+			"pod", pod,
+			"container", name)
+		return false, nil
+	}, ctx.Done())
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestStartEphemeralContainer(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		state    corev1.ContainerState
+		cancel   bool
+		expected error
+	}{
+		{
+			name:  "running",
+			state: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		},
+		{
+			name:     "terminated",
+			state:    corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error"}},
+			expected: errEphemeralContainerTerminated,
+		},
+		{
+			name:     "cancelled",
+			state:    corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+			cancel:   true,
+			expected: wait.ErrWaitTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"},
+			}
+
+			var added corev1.EphemeralContainer
+			k8sClientset := k8sfake.NewSimpleClientset(pod)
+			k8sClientset.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+				if action.GetSubresource() != "ephemeralcontainers" {
+					return false, nil, nil
+				}
+
+				// simulate the kubelet starting the ephemeral container
+				ephemeralContainers := action.(k8stesting.UpdateAction).GetObject().(*corev1.EphemeralContainers)
+				added = ephemeralContainers.EphemeralContainers[len(ephemeralContainers.EphemeralContainers)-1]
+
+				updated := pod.DeepCopy()
+				updated.Spec.EphemeralContainers = ephemeralContainers.EphemeralContainers
+				updated.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{
+					{Name: added.Name, State: tc.state},
+				}
+
+				podsResource := corev1.SchemeGroupVersion.WithResource("pods")
+				return true, ephemeralContainers, k8sClientset.Tracker().Update(podsResource, updated, "test-ns")
+			})
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("pod", "test-pod")
+			testConfig.Set("container", "test-container")
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sClientset,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel {
+				cancel()
+			}
+
+			name, err := clientset.StartEphemeralContainer(ctx, "busybox", []string{"sleep", "3600"})
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if tc.expected != nil {
+				return
+			}
+
+			if !strings.HasPrefix(name, "promdump-") || name != added.Name {
+				t.Errorf("mismatch container name. expected: %s, actual: %s", added.Name, name)
+			}
+
+			if added.TargetContainerName != "test-container" {
+				t.Errorf("mismatch target container. expected: test-container, actual: %s", added.TargetContainerName)
+			}
+		})
+	}
+}
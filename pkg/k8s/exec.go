@@ -2,29 +2,70 @@ package k8s
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"strings"
 
 	"github.com/go-kit/kit/log/level"
-	authzv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
-var deniedCreateExecErr = fmt.Errorf("no permissions to create exec subresource")
+const (
+	// exitCodeNotExecutable and exitCodeNotFound are the exit codes of the
+	// shells and container runtimes when the command can't be run.
+	exitCodeNotExecutable = 126
+	exitCodeNotFound      = 127
+)
+
+const (
+	// ExecTransportSPDY streams exec requests over SPDY.
+	ExecTransportSPDY = "spdy"
+
+	// ExecTransportWebSocket streams exec requests over WebSocket, with the
+	// channel protocol of the exec subresource.
+	ExecTransportWebSocket = "websocket"
+
+	// ExecTransportAuto streams exec requests over SPDY, and falls back to
+	// WebSocket if the SPDY upgrade fails, e.g. when it's blocked by a proxy.
+	ExecTransportAuto = "auto"
+)
+
+var (
+	deniedCreateExecErr         = fmt.Errorf("no permissions to create exec subresource")
+	errUnsupportedExecTransport = fmt.Errorf("unsupported exec transport")
+)
+
+// ValidateExecTransport returns an error if transport isn't a supported exec
+// transport.
+func ValidateExecTransport(transport string) error {
+	switch transport {
+	case ExecTransportSPDY, ExecTransportWebSocket, ExecTransportAuto:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedExecTransport, transport)
+	}
+}
 
 // ExecPod issues an exec request to execute the given command to a particular
-// pod.
-func (c *Clientset) ExecPod(command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
+// pod. The exec stream is closed when ctx is done.
+func (c *Clientset) ExecPod(ctx context.Context, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
+	return c.ExecContainer(ctx, c.config.GetString("container"), command, stdin, stdout, stderr, tty)
+}
+
+// ExecContainer issues an exec request to execute the given command to a
+// particular container of the pod. It is used to exec into ephemeral
+// containers. The exec stream is closed when ctx is done.
+func (c *Clientset) ExecContainer(ctx context.Context, container string, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
 	var (
 		ns             = c.config.GetString("namespace")
 		pod            = c.config.GetString("pod")
-		container      = c.config.GetString("container")
 		requestTimeout = c.config.GetDuration("request-timeout")
 		minTime        = c.config.GetTime("min-time")
 		maxTime        = c.config.GetTime("max-time")
@@ -54,12 +95,12 @@ func (c *Clientset) ExecPod(command []string, stdin io.Reader, stdout, stderr io
 		TTY:       tty,
 	}, scheme.ParameterCodec)
 
-	exec, err := newExecutor(c.k8sConfig, "POST", execRequest.URL())
+	exec, err := c.executor(execRequest.URL())
 	if err != nil {
 		return fmt.Errorf("failed to set up executor: %w", err)
 	}
 
-	if err := exec.Stream(remotecommand.StreamOptions{
+	if err := streamWithContext(ctx, exec, remotecommand.StreamOptions{
 		Stdin:  stdin,
 		Stdout: stdout,
 		Stderr: stderr,
@@ -71,51 +112,78 @@ func (c *Clientset) ExecPod(command []string, stdin io.Reader, stdout, stderr io
 	return nil
 }
 
-// CanExec determines if the current user can create a exec subresource in the
-// given pod.
-func (c *Clientset) CanExec() error {
-	var (
-		ns      = c.config.GetString("namespace")
-		timeout = c.config.GetDuration("request-timeout")
-	)
-	selfAccessReview := &authzv1.SelfSubjectAccessReview{
-		Spec: authzv1.SelfSubjectAccessReviewSpec{
-			ResourceAttributes: &authzv1.ResourceAttributes{
-				Namespace:   ns,
-				Verb:        "create",
-				Group:       "",
-				Resource:    "pods",
-				Subresource: "exec",
-				Name:        "",
-			},
-		},
+// IsCommandNotFound returns true if err means that the command of an exec
+// request doesn't exist in the container, i.e. it exited with the code 126 or
+// 127, or the container runtime couldn't find its executable.
+func IsCommandNotFound(err error) bool {
+	if err == nil {
+		return false
 	}
 
-	_ = level.Info(c.logger).Log("message", "checking for exec permissions",
-		"namespace", ns,
-		"request-timeout", timeout)
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitStatus()
+		return code == exitCodeNotExecutable || code == exitCodeNotFound
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	return strings.Contains(err.Error(), "executable file not found")
+}
 
-	response, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, selfAccessReview, metav1.CreateOptions{})
+// CanExec determines if the current user can create a exec subresource in the
+// given pod.
+func (c *Clientset) CanExec() error {
+	denials, err := c.Preflight([]Permission{execPermission})
 	if err != nil {
 		return err
 	}
 
-	if !response.Status.Allowed {
-		if response.Status.Reason != "" {
-			return fmt.Errorf("%w. reason: %s", deniedCreateExecErr, response.Status.Reason)
+	if len(denials) > 0 {
+		if denials[0].Reason != "" {
+			return fmt.Errorf("%w. reason: %s", deniedCreateExecErr, denials[0].Reason)
 		}
 		return deniedCreateExecErr
 	}
 
-	_ = level.Info(c.logger).Log("message", "confirmed exec permissions",
-		"namespace", ns,
-		"request-timeout", timeout)
 	return nil
 }
 
-var newExecutor = func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
-	return remotecommand.NewSPDYExecutor(config, method, url)
+// executor returns the executor of the exec request at url, based on the
+// exec-transport option. SPDY is used by default.
+func (c *Clientset) executor(url *url.URL) (remotecommand.Executor, error) {
+	transport := c.config.GetString("exec-transport")
+	switch transport {
+	case "", ExecTransportSPDY:
+		return newExecutor(c.k8sConfig, "POST", url)
+	case ExecTransportWebSocket:
+		return newWebSocketExecutor(c.k8sConfig, "GET", url)
+	case ExecTransportAuto:
+		return &fallbackExecutor{
+			config: c.k8sConfig,
+			url:    url,
+			logger: c.logger,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedExecTransport, transport)
+	}
+}
+
+// newExecutor returns the SPDY executor. It's a variable so that it can be
+// replaced in tests.
+var newExecutor = newSPDYExecutor
+
+// newSPDYExecutor returns a SPDY executor, whose connections are closed by its
+// Close() method.
+func newSPDYExecutor(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := &connTracker{Upgrader: upgrader}
+	exec, err := remotecommand.NewSPDYExecutorForTransports(transport, conns, method, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &closableExecutor{exec, conns}, nil
 }
@@ -1,14 +1,20 @@
 package k8s
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apiruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
@@ -16,6 +22,7 @@ import (
 	fakerest "k8s.io/client-go/rest/fake"
 	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 
 	"github.com/ihcsim/promdump/pkg/config"
 	"github.com/ihcsim/promdump/pkg/k8s/fake"
@@ -23,6 +30,30 @@ import (
 	"github.com/spf13/viper"
 )
 
+func TestIsCommandNotFound(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil},
+		{name: "not found", err: fmt.Errorf("failed to exec command: %w", utilexec.CodeExitError{Err: fmt.Errorf("command terminated with non-zero exit code: 127"), Code: 127}), expected: true},
+		{name: "not executable", err: fmt.Errorf("failed to exec command: %w", utilexec.CodeExitError{Err: fmt.Errorf("command terminated with non-zero exit code: 126"), Code: 126}), expected: true},
+		{name: "runtime", err: fmt.Errorf(`failed to exec command: error executing remote command: OCI runtime exec failed: exec failed: unable to start container process: exec: "tar": executable file not found in $PATH: unknown`), expected: true},
+		{name: "non-zero exit code", err: fmt.Errorf("failed to exec command: %w", utilexec.CodeExitError{Err: fmt.Errorf("command terminated with non-zero exit code: 2"), Code: 2})},
+		{name: "forbidden", err: apierrors.NewForbidden(corev1.Resource("pods"), "test-pod", fmt.Errorf("denied"))},
+		{name: "connection reset", err: fmt.Errorf("read tcp: %w", syscall.ECONNRESET)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := IsCommandNotFound(tc.err); actual != tc.expected {
+				t.Errorf("mismatch result. expected: %t, actual: %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func TestCanExec(t *testing.T) {
 
 	var testCases = []struct {
@@ -93,9 +124,28 @@ func TestExec(t *testing.T) {
 	newExecutor = newFakeExecutor
 
 	cmd := []string{}
-	if err := clientset.ExecPod(cmd, os.Stdin, os.Stdout, os.Stderr, false); err != nil {
+	if err := clientset.ExecPod(context.Background(), cmd, os.Stdin, os.Stdout, os.Stderr, false); err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		var executor *fake.Executor
+		newExecutor = func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+			executor = fake.NewExecutor(url)
+			executor.Block = true
+			return executor, nil
+		}
+		defer func() {
+			newExecutor = newFakeExecutor
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := clientset.ExecPod(ctx, cmd, nil, io.Discard, io.Discard, false); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("mismatch errors: expected: %v, actual: %v", context.DeadlineExceeded, err)
+		}
+	})
 }
 
 func newFakeExecutor(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
@@ -2,6 +2,7 @@ package fake
 
 import (
 	"net/url"
+	"sync"
 
 	"k8s.io/client-go/tools/remotecommand"
 )
@@ -10,15 +11,50 @@ import (
 // purpose. See https://pkg.go.dev/k8s.io/client-go/tools/remotecommand#Executor.
 type Executor struct {
 	ServerURL *url.URL
+
+	// Block makes Stream() block until Close() is called, to simulate a
+	// long-running remote command.
+	Block bool
+
+	// Errors are returned by the successive calls to Stream(). Once they are
+	// exhausted, Stream() returns nil.
+	Errors []error
+
+	// Calls is the number of calls to Stream().
+	Calls int
+
+	once   sync.Once
+	closed chan struct{}
 }
 
 // NewExecutor returns a new instance of Executor.
 func NewExecutor(serverURL *url.URL) *Executor {
-	return &Executor{serverURL}
+	return &Executor{
+		ServerURL: serverURL,
+		closed:    make(chan struct{}),
+	}
 }
 
 // Stream provides the implementation to satisfy the remotecommand.Executor
 // interface.
 func (f *Executor) Stream(options remotecommand.StreamOptions) error {
+	f.Calls++
+	if f.Block {
+		<-f.closed
+	}
+
+	if len(f.Errors) > 0 {
+		err := f.Errors[0]
+		f.Errors = f.Errors[1:]
+		return err
+	}
+	return nil
+}
+
+// Close unblocks Stream(), like closing the connection of the exec stream.
+func (f *Executor) Close() error {
+	f.once.Do(func() {
+		close(f.closed)
+	})
 	return nil
 }
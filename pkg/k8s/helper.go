@@ -0,0 +1,208 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// HelperContainer is the name of the container of the helper pod.
+	HelperContainer = "promdump"
+
+	helperVolume = "data"
+
+	// helperLifetime is how long the helper pod is allowed to run for, in case
+	// it isn't deleted by promdump.
+	helperLifetime = time.Hour
+)
+
+var (
+	errHelperPodTerminated = fmt.Errorf("helper pod terminated")
+
+	// helperPollInterval is the interval at which the helper pod is polled for
+	// its status.
+	helperPollInterval = time.Second
+
+	// helperStartTimeout is how long to wait for the helper pod to start,
+	// including the time it takes to attach the volume and pull the image.
+	helperStartTimeout = 2 * time.Minute
+)
+
+// StartHelperPod creates a pod which mounts the PVC claim read-only at
+// mountPath, and keeps it running with the sleep command of image, until it
+// reaches its lifetime. If the PVC can only be mounted by a
+// single node and it's in use by another pod, the helper pod is scheduled on
+// the same node. It returns the name of the helper pod, once it's running.
+// The helper pod should be deleted with DeletePod.
+func (c *Clientset) StartHelperPod(ctx context.Context, claim, image, mountPath string) (string, error) {
+	var (
+		ns   = c.config.GetString("namespace")
+		name = "promdump-" + utilrand.String(5)
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, helperStartTimeout)
+	defer cancel()
+
+	pvc, err := c.CoreV1().PersistentVolumeClaims(ns).Get(ctx, claim, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	activeDeadlineSeconds := int64(helperLifetime.Seconds())
+	command := []string{"sleep", strconv.FormatInt(activeDeadlineSeconds, 10)}
+	automountServiceAccountToken := false
+	helper := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "promdump",
+				"app.kubernetes.io/managed-by": "promdump",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:            HelperContainer,
+					Image:           image,
+					Command:         command,
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: helperVolume, MountPath: mountPath, ReadOnly: true},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: helperVolume,
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: claim,
+							ReadOnly:  true,
+						},
+					},
+				},
+			},
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &activeDeadlineSeconds,
+			AutomountServiceAccountToken: &automountServiceAccountToken,
+		},
+	}
+
+	if isReadWriteOnce(pvc) {
+		user, err := c.claimUser(ctx, claim)
+		if err != nil {
+			return "", err
+		}
+
+		if user != nil {
+			// a RWO volume can only be attached to one node
+			helper.Spec.NodeName = user.Spec.NodeName
+			helper.Spec.Tolerations = user.Spec.Tolerations
+		}
+	}
+
+	_ = level.Info(c.logger).Log("message", "starting helper pod",
+		"namespace", ns,
+		"pod", name,
+		"pvc", claim,
+		"image", image,
+		"node", helper.Spec.NodeName)
+
+	if _, err := c.CoreV1().Pods(ns).Create(ctx, helper, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create helper pod: %w", err)
+	}
+
+	if err := c.waitForHelperPod(ctx, name); err != nil {
+		// ctx may be done, and the pod must be deleted anyway
+		_ = c.DeletePod(context.Background(), name)
+		return "", fmt.Errorf("helper pod %s didn't start: %w", name, err)
+	}
+
+	return name, nil
+}
+
+// DeletePod deletes the pod with the given name, without waiting for its
+// containers to terminate gracefully.
+func (c *Clientset) DeletePod(ctx context.Context, name string) error {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_ = level.Info(c.logger).Log("message", "deleting pod",
+		"namespace", ns,
+		"pod", name)
+
+	gracePeriodSeconds := int64(0)
+	return c.CoreV1().Pods(ns).Delete(ctx, name, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+	})
+}
+
+// claimUser returns a scheduled pod which mounts the PVC claim, or nil if
+// there is none.
+func (c *Clientset) claimUser(ctx context.Context, claim string) (*corev1.Pod, error) {
+	ns := c.config.GetString("namespace")
+	pods, err := c.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, pod := range pods.Items {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim {
+				return &pods.Items[i], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *Clientset) waitForHelperPod(ctx context.Context, name string) error {
+	ns := c.config.GetString("namespace")
+	return wait.PollImmediateUntil(helperPollInterval, func() (bool, error) {
+		current, err := c.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		switch current.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodSucceeded, corev1.PodFailed:
+			return false, fmt.Errorf("%w: %s", errHelperPodTerminated, current.Status.Reason)
+		}
+
+		_ = level.Debug(c.logger).Log("message", "waiting for helper pod to start",
+			"namespace", ns,
+			"pod", name,
+			"phase", current.Status.Phase)
+		return false, nil
+	}, ctx.Done())
+}
+
+func isReadWriteOnce(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == corev1.ReadWriteOnce {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestStartHelperPod(t *testing.T) {
+	var (
+		ns   = "test-ns"
+		user = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus-0", Namespace: ns},
+			Spec: corev1.PodSpec{
+				NodeName:    "node-01",
+				Tolerations: []corev1.Toleration{{Key: "monitoring", Operator: corev1.TolerationOpExists}},
+				Volumes: []corev1.Volume{
+					{
+						Name: "storage",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "prometheus-data"},
+						},
+					},
+				},
+			},
+		}
+	)
+
+	newPVC := func(mode corev1.PersistentVolumeAccessMode) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus-data", Namespace: ns},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{mode},
+			},
+		}
+	}
+
+	var testCases = []struct {
+		name         string
+		objects      []apiruntime.Object
+		phase        corev1.PodPhase
+		expected     error
+		expectedNode string
+	}{
+		{
+			name:         "read-write-once in use",
+			objects:      []apiruntime.Object{newPVC(corev1.ReadWriteOnce), user},
+			phase:        corev1.PodRunning,
+			expectedNode: "node-01",
+		},
+		{
+			name:    "read-write-once not in use",
+			objects: []apiruntime.Object{newPVC(corev1.ReadWriteOnce)},
+			phase:   corev1.PodRunning,
+		},
+		{
+			name:    "read-only-many",
+			objects: []apiruntime.Object{newPVC(corev1.ReadOnlyMany), user},
+			phase:   corev1.PodRunning,
+		},
+		{
+			name:     "failed",
+			objects:  []apiruntime.Object{newPVC(corev1.ReadWriteOnce), user},
+			phase:    corev1.PodFailed,
+			expected: errHelperPodTerminated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				created *corev1.Pod
+				deleted string
+			)
+			k8sClientset := k8sfake.NewSimpleClientset(tc.objects...)
+			k8sClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+				// simulate the kubelet starting the helper pod
+				created = action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).DeepCopy()
+				created.Status.Phase = tc.phase
+				return true, created, k8sClientset.Tracker().Add(created)
+			})
+			k8sClientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+				deleted = action.(k8stesting.DeleteAction).GetName()
+				return false, nil, nil
+			})
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", ns)
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sClientset,
+			}
+
+			name, err := clientset.StartHelperPod(context.Background(), "prometheus-data", "busybox", "/data")
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if tc.expected != nil {
+				if deleted != created.Name {
+					t.Errorf("expected failed helper pod %s to be deleted", created.Name)
+				}
+				return
+			}
+
+			if name != created.Name {
+				t.Errorf("mismatch pod name. expected: %s, actual: %s", created.Name, name)
+			}
+
+			if actual := created.Spec.NodeName; actual != tc.expectedNode {
+				t.Errorf("mismatch node. expected: %q, actual: %q", tc.expectedNode, actual)
+			}
+
+			mount := created.Spec.Containers[0].VolumeMounts[0]
+			if !mount.ReadOnly || mount.MountPath != "/data" {
+				t.Errorf("expected PVC to be mounted read-only at /data, actual: %+v", mount)
+			}
+
+			if claim := created.Spec.Volumes[0].PersistentVolumeClaim; claim == nil || !claim.ReadOnly {
+				t.Errorf("expected read-only PVC volume, actual: %+v", created.Spec.Volumes[0])
+			}
+
+			// the helper pod sleeps for as long as it's allowed to run
+			if expected, actual := []string{"sleep", "3600"}, created.Spec.Containers[0].Command; !reflect.DeepEqual(actual, expected) {
+				t.Errorf("mismatch command. expected: %q, actual: %q", expected, actual)
+			}
+
+			if deadline := created.Spec.ActiveDeadlineSeconds; deadline == nil || *deadline != 3600 {
+				t.Errorf("mismatch active deadline. expected: 3600, actual: %v", deadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CoreOS is the only operating system the promdump binary is built for.
+const CoreOS = "linux"
+
+var (
+	// CoreArchs are the architectures the promdump binary is built for.
+	CoreArchs = []string{"amd64", "arm64", "ppc64le", "s390x"}
+
+	errUnsupportedPlatform = fmt.Errorf("unsupported platform")
+	errPodNotScheduled     = fmt.Errorf("pod isn't scheduled to a node")
+
+	// unameArchs maps the machine hardware names reported by 'uname -m' to
+	// the Go architectures.
+	unameArchs = map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"arm64":   "arm64",
+		"ppc64le": "ppc64le",
+		"s390x":   "s390x",
+	}
+)
+
+// ValidatePlatform returns an error if the promdump binary isn't built for the
+// os/arch platform.
+func ValidatePlatform(os, arch string) error {
+	if os == CoreOS {
+		for _, supported := range CoreArchs {
+			if arch == supported {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s/%s. promdump supports %s/{%s}", errUnsupportedPlatform, os, arch, CoreOS, strings.Join(CoreArchs, ","))
+}
+
+// NodePlatform returns the operating system and architecture of the node of
+// the pod. They are read from the well-known labels of the Node, or its node
+// info. Reading Nodes requires cluster-wide permissions, so if it's
+// forbidden, the architecture is read from 'uname -m' in the container.
+func (c *Clientset) NodePlatform(ctx context.Context, container string) (string, string, error) {
+	var (
+		ns      = c.config.GetString("namespace")
+		name    = c.config.GetString("pod")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pod, err := c.CoreV1().Pods(ns).Get(reqCtx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+
+	if pod.Spec.NodeName == "" {
+		return "", "", fmt.Errorf("%w: %s", errPodNotScheduled, name)
+	}
+
+	node, err := c.CoreV1().Nodes().Get(reqCtx, pod.Spec.NodeName, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) {
+		_ = level.Debug(c.logger).Log("message", "can't read node; falling back to uname",
+			"node", pod.Spec.NodeName,
+			"reason", err)
+		return c.unamePlatform(ctx, container)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	os, arch := nodePlatform(node)
+	_ = level.Info(c.logger).Log("message", "detected node platform",
+		"node", node.GetName(),
+		"os", os,
+		"arch", arch)
+	return os, arch, nil
+}
+
+func nodePlatform(node *corev1.Node) (string, string) {
+	var (
+		labels = node.GetLabels()
+		os     = labels[corev1.LabelOSStable]
+		arch   = labels[corev1.LabelArchStable]
+	)
+	if os == "" {
+		os = node.Status.NodeInfo.OperatingSystem
+	}
+	if arch == "" {
+		arch = node.Status.NodeInfo.Architecture
+	}
+
+	return os, arch
+}
+
+// unamePlatform returns the platform reported by 'uname -sm' in the
+// container.
+func (c *Clientset) unamePlatform(ctx context.Context, container string) (string, string, error) {
+	stdout := &bytes.Buffer{}
+	err := c.Retry(ctx, func() error {
+		stdout.Reset()
+		return c.ExecContainer(ctx, container, []string{"uname", "-sm"}, nil, stdout, io.Discard, false)
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("can't detect platform: %w", err)
+	}
+
+	fields := strings.Fields(stdout.String())
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("can't detect platform from uname output: %q", stdout.String())
+	}
+
+	os, arch := strings.ToLower(fields[0]), fields[1]
+	if goArch, ok := unameArchs[arch]; ok {
+		arch = goArch
+	}
+
+	_ = level.Info(c.logger).Log("message", "detected container platform",
+		"container", container,
+		"os", os,
+		"arch", arch)
+	return os, arch, nil
+}
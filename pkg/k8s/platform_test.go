@@ -0,0 +1,123 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestNodePlatform(t *testing.T) {
+	var (
+		ns  = "test-ns"
+		pod = func(nodeName string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "prometheus-0", Namespace: ns},
+				Spec:       corev1.PodSpec{NodeName: nodeName},
+			}
+		}
+	)
+
+	var testCases = []struct {
+		name         string
+		objects      []apiruntime.Object
+		expectedOS   string
+		expectedArch string
+		expected     error
+	}{
+		{
+			name: "labels",
+			objects: []apiruntime.Object{
+				pod("node-01"),
+				&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "node-01",
+						Labels: map[string]string{
+							corev1.LabelOSStable:   "linux",
+							corev1.LabelArchStable: "arm64",
+						},
+					},
+				},
+			},
+			expectedOS:   "linux",
+			expectedArch: "arm64",
+		},
+		{
+			name: "node info",
+			objects: []apiruntime.Object{
+				pod("node-01"),
+				&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-01"},
+					Status: corev1.NodeStatus{
+						NodeInfo: corev1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "s390x"},
+					},
+				},
+			},
+			expectedOS:   "linux",
+			expectedArch: "s390x",
+		},
+		{
+			name:     "not scheduled",
+			objects:  []apiruntime.Object{pod("")},
+			expected: errPodNotScheduled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", ns)
+			testConfig.Set("pod", "prometheus-0")
+			testConfig.Set("request-timeout", "5s")
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sfake.NewSimpleClientset(tc.objects...),
+			}
+
+			os, arch, err := clientset.NodePlatform(context.Background(), "prometheus-server")
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if os != tc.expectedOS || arch != tc.expectedArch {
+				t.Errorf("mismatch platform. expected: %s/%s, actual: %s/%s", tc.expectedOS, tc.expectedArch, os, arch)
+			}
+		})
+	}
+}
+
+func TestValidatePlatform(t *testing.T) {
+	var testCases = []struct {
+		os       string
+		arch     string
+		expected error
+	}{
+		{os: "linux", arch: "amd64"},
+		{os: "linux", arch: "arm64"},
+		{os: "linux", arch: "ppc64le"},
+		{os: "linux", arch: "s390x"},
+		{os: "linux", arch: "arm", expected: errUnsupportedPlatform},
+		{os: "windows", arch: "amd64", expected: errUnsupportedPlatform},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.os+"/"+tc.arch, func(t *testing.T) {
+			if err := ValidatePlatform(tc.os, tc.arch); !errors.Is(err, tc.expected) {
+				t.Errorf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+		})
+	}
+}
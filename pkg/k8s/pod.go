@@ -0,0 +1,91 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProtectAnnotation is the pod annotation that prevents promdump from
+// overwriting the pod's data, when set to "true".
+const ProtectAnnotation = "promdump.io/protect"
+
+var (
+	errProtectedPod = fmt.Errorf("pod is protected by the %s annotation", ProtectAnnotation)
+	errPodNotFound  = fmt.Errorf("no running pod matches selector")
+)
+
+// CanOverwrite determines if the data of the targeted pod can be overwritten.
+// Pods annotated with promdump.io/protect=true are protected.
+func (c *Clientset) CanOverwrite() error {
+	var (
+		ns      = c.config.GetString("namespace")
+		name    = c.config.GetString("pod")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	_ = level.Info(c.logger).Log("message", "checking for pod protection",
+		"namespace", ns,
+		"pod", name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pod, err := c.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if pod.GetAnnotations()[ProtectAnnotation] == "true" {
+		return errProtectedPod
+	}
+
+	return nil
+}
+
+// FindPod returns the name of the running pod matching the label selector. If
+// several pods match, e.g. Prometheus replicas, the first one by name is
+// returned.
+func (c *Clientset) FindPod(ctx context.Context, selector string) (string, error) {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pods, err := c.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning && pod.GetDeletionTimestamp() == nil {
+			names = append(names, pod.GetName())
+		}
+	}
+
+	if len(names) == 0 {
+		return "", fmt.Errorf("%w: %q (namespace: %s)", errPodNotFound, selector, ns)
+	}
+
+	sort.Strings(names)
+	if len(names) > 1 {
+		_ = level.Warn(c.logger).Log("message", "multiple pods match selector; using the first one",
+			"selector", selector,
+			"pods", fmt.Sprintf("%v", names),
+			"pod", names[0])
+	}
+
+	_ = level.Info(c.logger).Log("message", "found pod",
+		"namespace", ns,
+		"selector", selector,
+		"pod", names[0])
+	return names[0], nil
+}
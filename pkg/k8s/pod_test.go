@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestCanOverwrite(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		annotations map[string]string
+		expected    error
+	}{
+		{name: "no annotations"},
+		{name: "not protected", annotations: map[string]string{ProtectAnnotation: "false"}},
+		{name: "protected", annotations: map[string]string{ProtectAnnotation: "true"}, expected: errProtectedPod},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "test-ns",
+					Annotations: tc.annotations,
+				},
+			}
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("pod", "test-pod")
+			testConfig.Set("request-timeout", "5s")
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sfake.NewSimpleClientset(pod),
+			}
+
+			if actual := clientset.CanOverwrite(); !errors.Is(actual, tc.expected) {
+				t.Errorf("mismatch errors: expected: %v, actual: %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFindPod(t *testing.T) {
+	newPod := func(name string, labels map[string]string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test-ns",
+				Labels:    labels,
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	var (
+		prometheus = map[string]string{"app": "prometheus"}
+		grafana    = map[string]string{"app": "grafana"}
+	)
+
+	var testCases = []struct {
+		name      string
+		pods      []runtime.Object
+		expected  string
+		expectErr error
+	}{
+		{
+			name:     "single pod",
+			pods:     []runtime.Object{newPod("prometheus-0", prometheus, corev1.PodRunning), newPod("grafana-0", grafana, corev1.PodRunning)},
+			expected: "prometheus-0",
+		},
+		{
+			name:     "replicas",
+			pods:     []runtime.Object{newPod("prometheus-1", prometheus, corev1.PodRunning), newPod("prometheus-0", prometheus, corev1.PodRunning)},
+			expected: "prometheus-0",
+		},
+		{
+			name:     "pending replica",
+			pods:     []runtime.Object{newPod("prometheus-0", prometheus, corev1.PodPending), newPod("prometheus-1", prometheus, corev1.PodRunning)},
+			expected: "prometheus-1",
+		},
+		{
+			name:      "no match",
+			pods:      []runtime.Object{newPod("grafana-0", grafana, corev1.PodRunning)},
+			expectErr: errPodNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("request-timeout", "5s")
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sfake.NewSimpleClientset(tc.pods...),
+			}
+
+			actual, err := clientset.FindPod(context.Background(), "app=prometheus")
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expectErr, err)
+			}
+
+			if actual != tc.expected {
+				t.Errorf("mismatch pod. expected: %s, actual: %s", tc.expected, actual)
+			}
+		})
+	}
+}
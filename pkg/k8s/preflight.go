@@ -0,0 +1,395 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log/level"
+	authzv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// PreflightRoleName is the name of the Role, ClusterRole and their bindings
+// which grant the missing permissions.
+const PreflightRoleName = "promdump"
+
+var (
+	// ErrMissingPermissions is returned when the preflight checks find
+	// missing permissions.
+	ErrMissingPermissions = fmt.Errorf("missing permissions")
+
+	execPermission = Permission{Verb: "create", Resource: "pods", Subresource: "exec", Purpose: "run the promdump binary"}
+)
+
+// Permission is a permission needed by promdump. It's checked with a
+// SelfSubjectAccessReview.
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+
+	// Cluster is true if the resource is cluster-scoped. The permission is
+	// granted by a ClusterRole.
+	Cluster bool
+
+	// Optional is true if promdump falls back to another way when the
+	// permission is denied.
+	Optional bool
+
+	// Purpose describes why the permission is needed.
+	Purpose string
+}
+
+// String returns the permission in the form of 'verb resource/subresource'.
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	return p.Verb + " " + resource
+}
+
+// Denial is a permission denied to the current user.
+type Denial struct {
+	Permission
+
+	// Reason is the reason of the denial, reported by the authorizer. It may
+	// be empty.
+	Reason string
+}
+
+// PreflightOptions describe the operations of a subcommand, which determine
+// the permissions it needs.
+type PreflightOptions struct {
+	// Via is the way the promdump binary runs (exec or ephemeral).
+	Via string
+
+	// Helper is true if the data is read by a helper pod, from a persistent
+	// volume claim.
+	Helper bool
+
+	// Snapshot is true if the data is read from a volume snapshot.
+	Snapshot bool
+
+	// RestartStrategy is the strategy used to restart the Prometheus pod, or
+	// empty if the pod isn't restarted.
+	RestartStrategy string
+
+	// Selector is true if the Prometheus pod is found by its label selector.
+	Selector bool
+
+	// DetectPlatform is true if the platform of the node of the pod is
+	// detected, to pick the promdump binary built for it.
+	DetectPlatform bool
+}
+
+// Permissions returns the permissions needed by the operations described by
+// opts.
+func Permissions(opts PreflightOptions) []Permission {
+	perms := []Permission{execPermission}
+
+	if opts.Selector {
+		perms = append(perms, Permission{Verb: "list", Resource: "pods", Purpose: "find the pod matching the pod selector"})
+	}
+
+	if opts.DetectPlatform {
+		perms = append(perms, Permission{Verb: "get", Resource: "nodes", Cluster: true, Optional: true, Purpose: "detect the node platform, instead of running uname"})
+	}
+
+	if opts.Helper || opts.Snapshot {
+		perms = append(perms,
+			Permission{Verb: "get", Resource: "persistentvolumeclaims", Purpose: "read the persistent volume claim"},
+			Permission{Verb: "create", Resource: "pods", Purpose: "start the helper pod"},
+			Permission{Verb: "get", Resource: "pods", Purpose: "wait for the helper pod"},
+			Permission{Verb: "list", Resource: "pods", Purpose: "find the node of the persistent volume claim"},
+			Permission{Verb: "delete", Resource: "pods", Purpose: "delete the helper pod"},
+		)
+	} else {
+		perms = append(perms, Permission{Verb: "get", Resource: "pods", Purpose: "read the Prometheus pod"})
+	}
+
+	if opts.Snapshot {
+		perms = append(perms,
+			Permission{Verb: "create", Group: snapshotAPIGroup, Resource: "volumesnapshots", Purpose: "snapshot the persistent volume claim"},
+			Permission{Verb: "get", Group: snapshotAPIGroup, Resource: "volumesnapshots", Purpose: "wait for the volume snapshot"},
+			Permission{Verb: "delete", Group: snapshotAPIGroup, Resource: "volumesnapshots", Purpose: "delete the volume snapshot"},
+			Permission{Verb: "create", Resource: "persistentvolumeclaims", Purpose: "restore the volume snapshot"},
+			Permission{Verb: "delete", Resource: "persistentvolumeclaims", Purpose: "delete the restored persistent volume claim"},
+		)
+	}
+
+	switch {
+	case opts.Via == ViaEphemeral:
+		perms = append(perms, Permission{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers", Purpose: "start the ephemeral container"})
+	case !opts.Helper && !opts.Snapshot:
+		// the ephemeral container is only started if tar is missing in the
+		// Prometheus container
+		perms = append(perms, Permission{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers", Optional: true, Purpose: "start an ephemeral container if tar is missing"})
+	}
+
+	if opts.RestartStrategy != "" {
+		perms = append(perms, Permission{Verb: "list", Resource: "pods", Purpose: "wait for the restarted pod"})
+		for _, resource := range []string{"statefulsets", "deployments", "daemonsets", "replicasets"} {
+			perms = append(perms, Permission{Verb: "get", Group: "apps", Resource: resource, Purpose: "find the controller of the pod"})
+		}
+
+		switch opts.RestartStrategy {
+		case RestartDelete:
+			perms = append(perms, Permission{Verb: "delete", Resource: "pods", Purpose: "restart the pod"})
+		case RestartRollout:
+			for _, resource := range []string{"statefulsets", "deployments", "daemonsets"} {
+				perms = append(perms, Permission{Verb: "patch", Group: "apps", Resource: resource, Purpose: "roll out the controller of the pod"})
+			}
+		}
+	}
+
+	return dedupPermissions(perms)
+}
+
+// Preflight checks all the permissions with SelfSubjectAccessReviews, in the
+// namespace of the Prometheus pod, or cluster-wide for the cluster-scoped
+// resources. It returns the permissions which are denied.
+func (c *Clientset) Preflight(perms []Permission) ([]Denial, error) {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	_ = level.Info(c.logger).Log("message", "checking for permissions",
+		"namespace", ns,
+		"count", len(perms))
+
+	var denials []Denial
+	for _, perm := range perms {
+		namespace := ns
+		if perm.Cluster {
+			namespace = ""
+		}
+
+		review := &authzv1.SelfSubjectAccessReview{
+			Spec: authzv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authzv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        perm.Verb,
+					Group:       perm.Group,
+					Resource:    perm.Resource,
+					Subresource: perm.Subresource,
+				},
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		response, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check permission to %s: %w", perm, err)
+		}
+
+		if !response.Status.Allowed {
+			denials = append(denials, Denial{Permission: perm, Reason: response.Status.Reason})
+		}
+	}
+
+	_ = level.Info(c.logger).Log("message", "checked permissions",
+		"namespace", ns,
+		"denied", len(denials))
+	return denials, nil
+}
+
+// PreflightSubject returns the subject of the RoleBinding which grants the
+// missing permissions. It's the impersonated user, or the basic auth user of
+// the kubeconfig. Otherwise, the user can't be determined, and false is
+// returned with a placeholder.
+func (c *Clientset) PreflightSubject() (rbacv1.Subject, bool) {
+	subject := rbacv1.Subject{
+		APIGroup: rbacv1.GroupName,
+		Kind:     rbacv1.UserKind,
+		Name:     "<user>",
+	}
+
+	switch {
+	case c.k8sConfig.Impersonate.UserName != "":
+		subject.Name = c.k8sConfig.Impersonate.UserName
+	case c.k8sConfig.Username != "":
+		subject.Name = c.k8sConfig.Username
+	default:
+		return subject, false
+	}
+
+	return subject, true
+}
+
+// PrintDenials prints the table of the denied permissions to w.
+func PrintDenials(w io.Writer, denials []Denial) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERB\tRESOURCE\tPURPOSE\tREASON")
+	for _, denial := range denials {
+		resource := strings.TrimPrefix(denial.String(), denial.Verb+" ")
+		purpose := denial.Purpose
+		if denial.Optional {
+			purpose += " (optional)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", denial.Verb, resource, purpose, denial.Reason)
+	}
+	return tw.Flush()
+}
+
+// RBACManifest returns the YAML manifest of a Role and a RoleBinding, which
+// grant the namespaced permissions to subject in the namespace. The
+// cluster-scoped permissions are granted by a ClusterRole and a
+// ClusterRoleBinding.
+func RBACManifest(ns string, subject rbacv1.Subject, perms []Permission) ([]byte, error) {
+	var namespaced, cluster []Permission
+	for _, perm := range perms {
+		if perm.Cluster {
+			cluster = append(cluster, perm)
+		} else {
+			namespaced = append(namespaced, perm)
+		}
+	}
+
+	var objs []interface{}
+	if len(namespaced) > 0 {
+		objs = append(objs,
+			&rbacv1.Role{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "Role",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PreflightRoleName,
+					Namespace: ns,
+				},
+				Rules: policyRules(namespaced),
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "RoleBinding",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      PreflightRoleName,
+					Namespace: ns,
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: rbacv1.GroupName,
+					Kind:     "Role",
+					Name:     PreflightRoleName,
+				},
+				Subjects: []rbacv1.Subject{subject},
+			})
+	}
+
+	if len(cluster) > 0 {
+		objs = append(objs,
+			&rbacv1.ClusterRole{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "ClusterRole",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: PreflightRoleName,
+				},
+				Rules: policyRules(cluster),
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "ClusterRoleBinding",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: PreflightRoleName,
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: rbacv1.GroupName,
+					Kind:     "ClusterRole",
+					Name:     PreflightRoleName,
+				},
+				Subjects: []rbacv1.Subject{subject},
+			})
+	}
+
+	var manifest []byte
+	for _, obj := range objs {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		manifest = append(manifest, "---\n"...)
+		manifest = append(manifest, data...)
+	}
+
+	return manifest, nil
+}
+
+// policyRules groups the verbs of perms by resource, in a stable order.
+func policyRules(perms []Permission) []rbacv1.PolicyRule {
+	var (
+		keys  []string
+		rules = map[string]*rbacv1.PolicyRule{}
+	)
+	for _, perm := range perms {
+		resource := perm.Resource
+		if perm.Subresource != "" {
+			resource += "/" + perm.Subresource
+		}
+
+		key := perm.Group + "/" + resource
+		rule, ok := rules[key]
+		if !ok {
+			rule = &rbacv1.PolicyRule{
+				APIGroups: []string{perm.Group},
+				Resources: []string{resource},
+			}
+			rules[key] = rule
+			keys = append(keys, key)
+		}
+
+		if !containsString(rule.Verbs, perm.Verb) {
+			rule.Verbs = append(rule.Verbs, perm.Verb)
+		}
+	}
+
+	sort.Strings(keys)
+	policyRules := make([]rbacv1.PolicyRule, 0, len(keys))
+	for _, key := range keys {
+		sort.Strings(rules[key].Verbs)
+		policyRules = append(policyRules, *rules[key])
+	}
+	return policyRules
+}
+
+// dedupPermissions removes the duplicated permissions, keeping the first
+// occurrence.
+func dedupPermissions(perms []Permission) []Permission {
+	var (
+		seen    = map[string]bool{}
+		deduped []Permission
+	)
+	for _, perm := range perms {
+		if key := perm.String(); !seen[key] {
+			seen[key] = true
+			deduped = append(deduped, perm)
+		}
+	}
+	return deduped
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
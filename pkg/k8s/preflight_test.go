@@ -0,0 +1,246 @@
+package k8s
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestPermissions(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		opts     PreflightOptions
+		expected []string
+	}{
+		{
+			name:     "exec",
+			opts:     PreflightOptions{Via: ViaExec},
+			expected: []string{"create pods/exec", "get pods", "update pods/ephemeralcontainers"},
+		},
+		{
+			name: "selector",
+			opts: PreflightOptions{Via: ViaExec, Selector: true, DetectPlatform: true},
+			expected: []string{
+				"create pods/exec",
+				"list pods",
+				"get nodes",
+				"get pods",
+				"update pods/ephemeralcontainers",
+			},
+		},
+		{
+			name:     "ephemeral",
+			opts:     PreflightOptions{Via: ViaEphemeral},
+			expected: []string{"create pods/exec", "get pods", "update pods/ephemeralcontainers"},
+		},
+		{
+			name: "helper",
+			opts: PreflightOptions{Via: ViaExec, Helper: true},
+			expected: []string{
+				"create pods/exec",
+				"get persistentvolumeclaims",
+				"create pods",
+				"get pods",
+				"list pods",
+				"delete pods",
+			},
+		},
+		{
+			name: "snapshot",
+			opts: PreflightOptions{Via: ViaExec, Snapshot: true},
+			expected: []string{
+				"create pods/exec",
+				"get persistentvolumeclaims",
+				"create pods",
+				"get pods",
+				"list pods",
+				"delete pods",
+				"create volumesnapshots.snapshot.storage.k8s.io",
+				"get volumesnapshots.snapshot.storage.k8s.io",
+				"delete volumesnapshots.snapshot.storage.k8s.io",
+				"create persistentvolumeclaims",
+				"delete persistentvolumeclaims",
+			},
+		},
+		{
+			name: "restart rollout",
+			opts: PreflightOptions{Via: ViaExec, RestartStrategy: RestartRollout},
+			expected: []string{
+				"create pods/exec",
+				"get pods",
+				"update pods/ephemeralcontainers",
+				"list pods",
+				"get statefulsets.apps",
+				"get deployments.apps",
+				"get daemonsets.apps",
+				"get replicasets.apps",
+				"patch statefulsets.apps",
+				"patch deployments.apps",
+				"patch daemonsets.apps",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []string
+			for _, perm := range Permissions(tc.opts) {
+				actual = append(actual, perm.String())
+
+				if optional := perm.Resource == "nodes" || (perm.Subresource == "ephemeralcontainers" && tc.opts.Via != ViaEphemeral); perm.Optional != optional {
+					t.Errorf("mismatch optional %s. expected: %t, actual: %t", perm, optional, perm.Optional)
+				}
+			}
+
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("mismatch permissions.\nexpected: %v\nactual:   %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	k8sClientset := &k8sfake.Clientset{}
+	k8sClientset.Fake.AddReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SelfSubjectAccessReview)
+
+		attrs := review.Spec.ResourceAttributes
+		if cluster := attrs.Resource == "nodes"; cluster != (attrs.Namespace == "") {
+			t.Errorf("mismatch namespace of %s: %q", attrs.Resource, attrs.Namespace)
+		}
+
+		// only the pod permissions are granted
+		allowed := attrs.Resource == "pods"
+		review.Status = authzv1.SubjectAccessReviewStatus{Allowed: allowed}
+		if !allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+
+	testConfig := &config.Config{Viper: viper.New()}
+	testConfig.Set("namespace", "test-ns")
+	clientset := Clientset{
+		testConfig,
+		&rest.Config{},
+		log.New("debug", io.Discard),
+		k8sClientset,
+	}
+
+	perms := Permissions(PreflightOptions{Via: ViaEphemeral, Snapshot: true, DetectPlatform: true})
+	denials, err := clientset.Preflight(perms)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	var actual []string
+	for _, denial := range denials {
+		actual = append(actual, denial.String())
+		if denial.Reason == "" {
+			t.Errorf("expected denial reason of %s", denial)
+		}
+	}
+
+	expected := []string{
+		"get nodes",
+		"get persistentvolumeclaims",
+		"create volumesnapshots.snapshot.storage.k8s.io",
+		"get volumesnapshots.snapshot.storage.k8s.io",
+		"delete volumesnapshots.snapshot.storage.k8s.io",
+		"create persistentvolumeclaims",
+		"delete persistentvolumeclaims",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("mismatch denials.\nexpected: %v\nactual:   %v", expected, actual)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintDenials(&buf, denials); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != len(denials)+1 {
+		t.Errorf("expected a header and %d rows, actual:\n%s", len(denials), buf.String())
+	}
+}
+
+func TestRBACManifest(t *testing.T) {
+	subject := rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "jane"}
+	perms := Permissions(PreflightOptions{Via: ViaEphemeral, RestartStrategy: RestartDelete, DetectPlatform: true})
+
+	manifest, err := RBACManifest("test-ns", subject, perms)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	docs := strings.Split(strings.TrimPrefix(string(manifest), "---\n"), "---\n")
+	if len(docs) != 4 {
+		t.Fatalf("expected 4 YAML documents, actual: %d", len(docs))
+	}
+
+	var role rbacv1.Role
+	if err := yaml.Unmarshal([]byte(docs[0]), &role); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if role.Kind != "Role" || role.Namespace != "test-ns" {
+		t.Errorf("mismatch role: %+v", role.ObjectMeta)
+	}
+
+	expected := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete", "get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/ephemeralcontainers"}, Verbs: []string{"update"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/exec"}, Verbs: []string{"create"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"daemonsets"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"replicasets"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"get"}},
+	}
+	if !reflect.DeepEqual(expected, role.Rules) {
+		t.Errorf("mismatch rules.\nexpected: %+v\nactual:   %+v", expected, role.Rules)
+	}
+
+	var roleBinding rbacv1.RoleBinding
+	if err := yaml.Unmarshal([]byte(docs[1]), &roleBinding); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if roleBinding.RoleRef.Name != role.Name || !reflect.DeepEqual(roleBinding.Subjects, []rbacv1.Subject{subject}) {
+		t.Errorf("mismatch role binding: %+v", roleBinding)
+	}
+
+	var clusterRole rbacv1.ClusterRole
+	if err := yaml.Unmarshal([]byte(docs[2]), &clusterRole); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	expected = []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get"}},
+	}
+	if clusterRole.Kind != "ClusterRole" || !reflect.DeepEqual(expected, clusterRole.Rules) {
+		t.Errorf("mismatch cluster role: %+v", clusterRole)
+	}
+
+	var clusterRoleBinding rbacv1.ClusterRoleBinding
+	if err := yaml.Unmarshal([]byte(docs[3]), &clusterRoleBinding); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if clusterRoleBinding.RoleRef.Kind != "ClusterRole" || clusterRoleBinding.RoleRef.Name != clusterRole.Name {
+		t.Errorf("mismatch cluster role binding: %+v", clusterRoleBinding)
+	}
+}
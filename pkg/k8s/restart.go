@@ -0,0 +1,232 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// RestartDelete restarts the pod by deleting it. Its controller then
+	// re-creates it.
+	RestartDelete = "delete"
+
+	// RestartRollout restarts the pod by triggering a rollout of its
+	// StatefulSet or Deployment, like 'kubectl rollout restart' does.
+	RestartRollout = "rollout"
+
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+var (
+	errNoController               = fmt.Errorf("pod isn't managed by a controller; it won't be re-created")
+	errUnsupportedRestartStrategy = fmt.Errorf("unsupported restart strategy")
+
+	// restartPollInterval is the interval at which the pods are polled for
+	// readiness, after a restart.
+	restartPollInterval = 2 * time.Second
+)
+
+// controller describes the workload that manages a pod.
+type controller struct {
+	kind     string
+	name     string
+	selector labels.Selector
+}
+
+// ValidateRestartStrategy returns an error if strategy isn't a supported
+// restart strategy.
+func ValidateRestartStrategy(strategy string) error {
+	switch strategy {
+	case RestartDelete, RestartRollout:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedRestartStrategy, strategy)
+	}
+}
+
+// RestartPod restarts the pod according to strategy, and waits for its
+// replacement to become ready. It returns the name of the new pod, which
+// differs from the old one if the pod is managed by a Deployment. The restart
+// is cancelled with ctx, or after timeout.
+func (c *Clientset) RestartPod(ctx context.Context, strategy string, timeout time.Duration) (string, error) {
+	if err := ValidateRestartStrategy(strategy); err != nil {
+		return "", err
+	}
+
+	var (
+		ns   = c.config.GetString("namespace")
+		name = c.config.GetString("pod")
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pod, err := c.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	owner, err := c.podController(ctx, pod)
+	if err != nil {
+		return "", err
+	}
+
+	// truncated to seconds, to match the precision of the pods' creation
+	// timestamps
+	restartedAt := time.Now().Truncate(time.Second)
+	_ = level.Info(c.logger).Log("message", "restarting pod",
+		"namespace", ns,
+		"pod", name,
+		"strategy", strategy,
+		"controller", owner.kind+"/"+owner.name)
+
+	switch strategy {
+	case RestartDelete:
+		err = c.CoreV1().Pods(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	case RestartRollout:
+		err = c.rolloutRestart(ctx, ns, owner, restartedAt)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to restart pod: %w", err)
+	}
+
+	newPod, err := c.waitForReadyPod(ctx, pod, owner, restartedAt)
+	if err != nil {
+		return "", fmt.Errorf("pod didn't become ready after restart: %w", err)
+	}
+
+	_ = level.Info(c.logger).Log("message", "pod is ready",
+		"namespace", ns,
+		"pod", newPod.GetName())
+	return newPod.GetName(), nil
+}
+
+// podController returns the StatefulSet, Deployment, ReplicaSet or DaemonSet
+// that manages pod.
+func (c *Clientset) podController(ctx context.Context, pod *corev1.Pod) (*controller, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, errNoController
+	}
+
+	var (
+		ns       = pod.GetNamespace()
+		selector *metav1.LabelSelector
+	)
+	switch ref.Kind {
+	case "StatefulSet":
+		statefulSet, err := c.AppsV1().StatefulSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = statefulSet.Spec.Selector
+	case "DaemonSet":
+		daemonSet, err := c.AppsV1().DaemonSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = daemonSet.Spec.Selector
+	case "ReplicaSet":
+		replicaSet, err := c.AppsV1().ReplicaSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		// the pods of a Deployment are managed by its ReplicaSets
+		if deployRef := metav1.GetControllerOf(replicaSet); deployRef != nil && deployRef.Kind == "Deployment" {
+			deployment, err := c.AppsV1().Deployments(ns).Get(ctx, deployRef.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			ref, selector = deployRef, deployment.Spec.Selector
+			break
+		}
+		selector = replicaSet.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported controller %s/%s", ref.Kind, ref.Name)
+	}
+
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &controller{kind: ref.Kind, name: ref.Name, selector: labelSelector}, nil
+}
+
+func (c *Clientset) rolloutRestart(ctx context.Context, ns string, owner *controller, restartedAt time.Time) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, restartedAt.Format(time.RFC3339)))
+
+	var err error
+	switch owner.kind {
+	case "StatefulSet":
+		_, err = c.AppsV1().StatefulSets(ns).Patch(ctx, owner.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case "Deployment":
+		_, err = c.AppsV1().Deployments(ns).Patch(ctx, owner.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case "DaemonSet":
+		_, err = c.AppsV1().DaemonSets(ns).Patch(ctx, owner.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	default:
+		err = fmt.Errorf("can't rollout %s/%s", owner.kind, owner.name)
+	}
+
+	return err
+}
+
+// waitForReadyPod waits for the replacement of the old pod to become ready.
+// The pods of a StatefulSet keep their names. Otherwise, any ready pod of the
+// controller that is created after the restart is accepted.
+func (c *Clientset) waitForReadyPod(ctx context.Context, old *corev1.Pod, owner *controller, restartedAt time.Time) (*corev1.Pod, error) {
+	var ready *corev1.Pod
+	err := wait.PollImmediateUntil(restartPollInterval, func() (bool, error) {
+		pods, err := c.CoreV1().Pods(old.GetNamespace()).List(ctx, metav1.ListOptions{
+			LabelSelector: owner.selector.String(),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		for i, pod := range pods.Items {
+			if pod.GetUID() == old.GetUID() || pod.GetDeletionTimestamp() != nil {
+				continue
+			}
+
+			if owner.kind == "StatefulSet" && pod.GetName() != old.GetName() {
+				continue
+			}
+
+			if owner.kind != "StatefulSet" && pod.GetCreationTimestamp().Time.Before(restartedAt) {
+				continue
+			}
+
+			if isPodReady(&pod) {
+				ready = &pods.Items[i]
+				return true, nil
+			}
+		}
+
+		_ = level.Debug(c.logger).Log("message", "waiting for pod to become ready",
+			"namespace", old.GetNamespace(),
+			"controller", owner.kind+"/"+owner.name)
+		return false, nil
+	}, ctx.Done())
+
+	return ready, err
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestRestartPod(t *testing.T) {
+	var (
+		ns       = "test-ns"
+		selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "prometheus"}}
+		isTrue   = true
+	)
+
+	newPod := func(name, uid, ownerKind, ownerName string, created time.Time, ready bool) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         ns,
+				UID:               types.UID(uid),
+				Labels:            map[string]string{"app": "prometheus"},
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+			},
+		}
+		if ownerKind != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &isTrue}}
+		}
+		return pod
+	}
+
+	var (
+		past        = time.Now().Add(-time.Hour)
+		future      = time.Now().Add(time.Hour)
+		statefulSet = &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: ns},
+			Spec:       appsv1.StatefulSetSpec{Selector: selector},
+		}
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: ns},
+			Spec:       appsv1.DeploymentSpec{Selector: selector},
+		}
+		replicaSet = &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "prometheus-5d8f7",
+				Namespace:       ns,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "prometheus", Controller: &isTrue}},
+			},
+			Spec: appsv1.ReplicaSetSpec{Selector: selector},
+		}
+	)
+
+	var testCases = []struct {
+		name        string
+		strategy    string
+		pod         string
+		objects     []apiruntime.Object
+		verb        string
+		resource    string
+		replacement *corev1.Pod
+		expectedPod string
+		expectedErr error
+	}{
+		{
+			name:     "statefulset delete",
+			pod:      "prometheus-0",
+			strategy: RestartDelete,
+			objects: []apiruntime.Object{
+				statefulSet,
+				newPod("prometheus-0", "old", "StatefulSet", "prometheus", past, true),
+			},
+			verb:        "delete",
+			resource:    "pods",
+			replacement: newPod("prometheus-0", "new", "StatefulSet", "prometheus", future, true),
+			expectedPod: "prometheus-0",
+		},
+		{
+			name:     "deployment rollout",
+			pod:      "prometheus-5d8f7-abcde",
+			strategy: RestartRollout,
+			objects: []apiruntime.Object{
+				deployment,
+				replicaSet,
+				newPod("prometheus-5d8f7-abcde", "old", "ReplicaSet", "prometheus-5d8f7", past, true),
+				newPod("prometheus-5d8f7-fghij", "other", "ReplicaSet", "prometheus-5d8f7", past, true),
+			},
+			verb:        "patch",
+			resource:    "deployments",
+			replacement: newPod("prometheus-7c9a2-klmno", "new", "ReplicaSet", "prometheus-7c9a2", future, true),
+			expectedPod: "prometheus-7c9a2-klmno",
+		},
+		{
+			name:     "no controller",
+			pod:      "prometheus-0",
+			strategy: RestartDelete,
+			objects: []apiruntime.Object{
+				newPod("prometheus-0", "old", "", "", past, true),
+			},
+			expectedErr: errNoController,
+		},
+		{
+			name:        "unsupported strategy",
+			pod:         "prometheus-0",
+			strategy:    "recreate",
+			expectedErr: errUnsupportedRestartStrategy,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k8sClientset := k8sfake.NewSimpleClientset(tc.objects...)
+			if tc.replacement != nil {
+				// simulate the controller re-creating the pod
+				replacement := tc.replacement
+				k8sClientset.PrependReactor(tc.verb, tc.resource, func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+					if action.GetVerb() == "delete" {
+						// the pods of a StatefulSet are re-created with the
+						// same name
+						podsResource := corev1.SchemeGroupVersion.WithResource("pods")
+						return true, nil, k8sClientset.Tracker().Update(podsResource, replacement, ns)
+					}
+
+					if err := k8sClientset.Tracker().Add(replacement); err != nil {
+						return true, nil, err
+					}
+					return false, nil, nil
+				})
+			}
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", ns)
+			testConfig.Set("pod", tc.pod)
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sClientset,
+			}
+
+			actual, err := clientset.RestartPod(context.Background(), tc.strategy, 5*time.Second)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Errorf("mismatch errors: expected: %v, actual: %v", tc.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual != tc.expectedPod {
+				t.Errorf("mismatch pod. expected: %s, actual: %s", tc.expectedPod, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	// retryFactor is the multiplier of the interval between retries.
+	retryFactor = 2.0
+
+	// retryJitter adds up to 50% of the interval between retries, so that
+	// concurrent clients don't retry in lockstep.
+	retryJitter = 0.5
+)
+
+// transientMessages are found in errors which aren't wrapped by the SPDY
+// round tripper and the stream protocols of client-go.
+var transientMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"unexpected EOF",
+	"i/o timeout",
+	"error sending request",
+	"unable to upgrade connection",
+	"server sent GOAWAY",
+}
+
+// IsTransient returns true if err is a transient failure of an exec request,
+// like a reset connection, a 5xx response or a failed connection upgrade, after
+// which the request can be retried. A non-zero exit code of the remote command
+// isn't transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) {
+		code := statusErr.Status().Code
+		return code >= 500 || code == 429
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := err.Error()
+	for _, transient := range transientMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Retry calls fn until it succeeds, or until it fails with an error which
+// isn't transient. It's retried up to --exec-retries times, with an
+// exponential backoff starting at --exec-retry-interval, capped at
+// --exec-retry-max-interval, with jitter. Only idempotent exec requests should
+// be retried. fn must recreate its input and output streams on every call.
+func (c *Clientset) Retry(ctx context.Context, fn func() error) error {
+	var (
+		retries = c.config.GetInt("exec-retries")
+		backoff = wait.Backoff{
+			Duration: c.config.GetDuration("exec-retry-interval"),
+			Factor:   retryFactor,
+			Jitter:   retryJitter,
+			Steps:    retries,
+			Cap:      c.config.GetDuration("exec-retry-max-interval"),
+		}
+	)
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !IsTransient(err) {
+			return err
+		}
+
+		interval := backoff.Step()
+		_ = level.Warn(c.logger).Log("message", "retrying exec request after transient failure",
+			"attempt", attempt+1,
+			"retries", retries,
+			"interval", interval,
+			"reason", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+	}
+}
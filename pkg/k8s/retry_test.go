@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/k8s/fake"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestIsTransient(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil},
+		{name: "connection reset", err: fmt.Errorf("read tcp: %w", syscall.ECONNRESET), expected: true},
+		{name: "connection reset message", err: fmt.Errorf("error reading from error stream: read tcp 10.0.0.1:443: read: connection reset by peer"), expected: true},
+		{name: "upgrade failure", err: fmt.Errorf("unable to upgrade connection: "), expected: true},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("apiserver is shutting down"), expected: true},
+		{name: "internal error", err: apierrors.NewInternalError(fmt.Errorf("etcd timeout")), expected: true},
+		{name: "forbidden", err: apierrors.NewForbidden(corev1.Resource("pods"), "test-pod", fmt.Errorf("denied"))},
+		{name: "non-zero exit code", err: fmt.Errorf("failed to exec command: %w", utilexec.CodeExitError{Err: fmt.Errorf("command terminated with non-zero exit code: 1"), Code: 1})},
+		{name: "cancelled", err: context.Canceled},
+		{name: "other", err: fmt.Errorf("container not found")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := IsTransient(tc.err); actual != tc.expected {
+				t.Errorf("mismatch result. expected: %t, actual: %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var (
+		resetErr = fmt.Errorf("read tcp: %w", syscall.ECONNRESET)
+		exitErr  = utilexec.CodeExitError{Err: fmt.Errorf("command terminated with non-zero exit code: 2"), Code: 2}
+	)
+
+	var testCases = []struct {
+		name          string
+		errors        []error
+		expected      error
+		expectedCalls int
+	}{
+		{name: "success", expectedCalls: 1},
+		{name: "transient failures", errors: []error{resetErr, apierrors.NewServiceUnavailable("unavailable")}, expectedCalls: 3},
+		{name: "retries exhausted", errors: []error{resetErr, resetErr, resetErr, resetErr}, expected: syscall.ECONNRESET, expectedCalls: 4},
+		{name: "non-zero exit code", errors: []error{exitErr}, expected: exitErr, expectedCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("pod", "test-pod")
+			testConfig.Set("container", "test-container")
+			testConfig.Set("exec-retries", 3)
+			testConfig.Set("exec-retry-interval", time.Millisecond)
+			testConfig.Set("exec-retry-max-interval", 5*time.Millisecond)
+
+			restClient := &fakerest.RESTClient{
+				Client: fakerest.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK}, nil
+				}),
+			}
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				kubernetes.New(restClient),
+			}
+
+			executor := fake.NewExecutor(nil)
+			executor.Errors = tc.errors
+			newExecutor = func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+				return executor, nil
+			}
+			defer func() {
+				newExecutor = newFakeExecutor
+			}()
+
+			err := clientset.Retry(context.Background(), func() error {
+				return clientset.ExecPod(context.Background(), []string{"tar", "-xzf", "-"}, nil, io.Discard, io.Discard, false)
+			})
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("mismatch errors. expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if executor.Calls != tc.expectedCalls {
+				t.Errorf("mismatch exec requests. expected: %d, actual: %d", tc.expectedCalls, executor.Calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+const snapshotAPIGroup = "snapshot.storage.k8s.io"
+
+var (
+	errNoDataDirClaim = fmt.Errorf("data directory isn't backed by a persistent volume claim")
+	errSnapshotFailed = fmt.Errorf("volume snapshot failed")
+	errNoRestoreSize  = fmt.Errorf("volume snapshot has no restore size")
+
+	volumeSnapshotResource = schema.GroupVersionResource{
+		Group:    snapshotAPIGroup,
+		Version:  "v1",
+		Resource: "volumesnapshots",
+	}
+
+	// snapshotPollInterval is the interval at which the volume snapshot is
+	// polled for its status.
+	snapshotPollInterval = time.Second
+
+	// snapshotReadyTimeout is how long to wait for the volume snapshot to be
+	// ready to use.
+	snapshotReadyTimeout = 5 * time.Minute
+)
+
+var newDynamicClient = func(config *rest.Config) (dynamic.Interface, error) {
+	return dynamic.NewForConfig(config)
+}
+
+// DataDirClaim returns the persistent volume claim mounted at dataDir in the
+// Prometheus container, and the path of dataDir within the volume.
+func (c *Clientset) DataDirClaim(dataDir string) (string, string, error) {
+	var (
+		ns        = c.config.GetString("namespace")
+		pod       = c.config.GetString("pod")
+		container = c.config.GetString("container")
+		timeout   = c.config.GetDuration("request-timeout")
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	current, err := c.CoreV1().Pods(ns).Get(ctx, pod, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+
+	claims := map[string]string{}
+	for _, volume := range current.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+
+	// find the most specific mount containing the data directory
+	var (
+		mount *corev1.VolumeMount
+		rel   string
+	)
+	for _, ctr := range current.Spec.Containers {
+		if ctr.Name != container {
+			continue
+		}
+
+		for i, m := range ctr.VolumeMounts {
+			r, ok := relPath(m.MountPath, dataDir)
+			if !ok {
+				continue
+			}
+
+			if mount == nil || len(m.MountPath) > len(mount.MountPath) {
+				mount = &ctr.VolumeMounts[i]
+				rel = r
+			}
+		}
+	}
+
+	if mount == nil {
+		return "", "", fmt.Errorf("%w: %s", errNoDataDirClaim, dataDir)
+	}
+
+	claim, ok := claims[mount.Name]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s is mounted from volume %s", errNoDataDirClaim, dataDir, mount.Name)
+	}
+
+	return claim, path.Join(mount.SubPath, rel), nil
+}
+
+// CreateVolumeSnapshot creates a CSI volume snapshot of the persistent volume
+// claim, with the volume snapshot class snapshotClass, or the default class
+// if it's empty. It returns the name of the volume snapshot, once it's ready
+// to use, or when ctx is done. The volume snapshot should be deleted with
+// DeleteVolumeSnapshot.
+func (c *Clientset) CreateVolumeSnapshot(ctx context.Context, claim, snapshotClass string) (string, error) {
+	var (
+		ns   = c.config.GetString("namespace")
+		name = "promdump-" + utilrand.String(5)
+	)
+
+	client, err := newDynamicClient(c.k8sConfig)
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": volumeSnapshotResource.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": ns,
+				"labels": map[string]interface{}{
+					"app.kubernetes.io/name":       "promdump",
+					"app.kubernetes.io/managed-by": "promdump",
+				},
+			},
+			"spec": map[string]interface{}{
+				"source": map[string]interface{}{
+					"persistentVolumeClaimName": claim,
+				},
+			},
+		},
+	}
+	if snapshotClass != "" {
+		if err := unstructured.SetNestedField(snapshot.Object, snapshotClass, "spec", "volumeSnapshotClassName"); err != nil {
+			return "", err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotReadyTimeout)
+	defer cancel()
+
+	_ = level.Info(c.logger).Log("message", "creating volume snapshot",
+		"namespace", ns,
+		"snapshot", name,
+		"pvc", claim,
+		"class", snapshotClass)
+
+	if _, err := client.Resource(volumeSnapshotResource).Namespace(ns).Create(ctx, snapshot, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create volume snapshot: %w", err)
+	}
+
+	if err := c.waitForVolumeSnapshot(ctx, client, name); err != nil {
+		// ctx may be done, and the volume snapshot must be deleted anyway
+		_ = c.DeleteVolumeSnapshot(context.Background(), name)
+		return "", fmt.Errorf("volume snapshot %s isn't ready: %w", name, err)
+	}
+
+	return name, nil
+}
+
+// CreateClaimFromSnapshot provisions a new persistent volume claim from the
+// volume snapshot, using the storage class of the source claim. It returns the
+// name of the new claim, which should be deleted with DeleteClaim.
+func (c *Clientset) CreateClaimFromSnapshot(ctx context.Context, source, snapshot string) (string, error) {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+		name    = "promdump-" + utilrand.String(5)
+	)
+
+	client, err := newDynamicClient(c.k8sConfig)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sourceClaim, err := c.CoreV1().PersistentVolumeClaims(ns).Get(ctx, source, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	current, err := client.Resource(volumeSnapshotResource).Namespace(ns).Get(ctx, snapshot, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	restoreSize, _, err := unstructured.NestedString(current.Object, "status", "restoreSize")
+	if err != nil {
+		return "", err
+	}
+
+	size, ok := sourceClaim.Spec.Resources.Requests[corev1.ResourceStorage]
+	if restoreSize != "" {
+		if size, err = resource.ParseQuantity(restoreSize); err != nil {
+			return "", err
+		}
+	} else if !ok {
+		return "", fmt.Errorf("%w: %s", errNoRestoreSize, snapshot)
+	}
+
+	apiGroup := snapshotAPIGroup
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "promdump",
+				"app.kubernetes.io/managed-by": "promdump",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: sourceClaim.Spec.StorageClassName,
+			VolumeMode:       sourceClaim.Spec.VolumeMode,
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshot,
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+
+	_ = level.Info(c.logger).Log("message", "creating persistent volume claim from volume snapshot",
+		"namespace", ns,
+		"pvc", name,
+		"snapshot", snapshot,
+		"size", size.String())
+
+	if _, err := c.CoreV1().PersistentVolumeClaims(ns).Create(ctx, claim, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create persistent volume claim: %w", err)
+	}
+
+	return name, nil
+}
+
+// DeleteVolumeSnapshot deletes the volume snapshot with the given name.
+func (c *Clientset) DeleteVolumeSnapshot(ctx context.Context, name string) error {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	client, err := newDynamicClient(c.k8sConfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_ = level.Info(c.logger).Log("message", "deleting volume snapshot",
+		"namespace", ns,
+		"snapshot", name)
+
+	return client.Resource(volumeSnapshotResource).Namespace(ns).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// DeleteClaim deletes the persistent volume claim with the given name.
+func (c *Clientset) DeleteClaim(ctx context.Context, name string) error {
+	var (
+		ns      = c.config.GetString("namespace")
+		timeout = c.config.GetDuration("request-timeout")
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_ = level.Info(c.logger).Log("message", "deleting persistent volume claim",
+		"namespace", ns,
+		"pvc", name)
+
+	return c.CoreV1().PersistentVolumeClaims(ns).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c *Clientset) waitForVolumeSnapshot(ctx context.Context, client dynamic.Interface, name string) error {
+	ns := c.config.GetString("namespace")
+	return wait.PollImmediateUntil(snapshotPollInterval, func() (bool, error) {
+		current, err := client.Resource(volumeSnapshotResource).Namespace(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if message, found, _ := unstructured.NestedString(current.Object, "status", "error", "message"); found {
+			return false, fmt.Errorf("%w: %s", errSnapshotFailed, message)
+		}
+
+		if ready, _, _ := unstructured.NestedBool(current.Object, "status", "readyToUse"); ready {
+			return true, nil
+		}
+
+		_ = level.Debug(c.logger).Log("message", "waiting for volume snapshot to be ready",
+			"namespace", ns,
+			"snapshot", name)
+		return false, nil
+	}, ctx.Done())
+}
+
+// relPath returns the path of target relative to base, if target is within
+// base. Paths in containers are always slash-separated.
+func relPath(base, target string) (string, bool) {
+	base, target = path.Clean(base), path.Clean(target)
+	if base == target {
+		return "", true
+	}
+
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	if !strings.HasPrefix(target, prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(target, prefix), true
+}
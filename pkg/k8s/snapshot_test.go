@@ -0,0 +1,220 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestVolumeSnapshot(t *testing.T) {
+	var (
+		ns           = "test-ns"
+		storageClass = "csi-hostpath-sc"
+		source       = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "prometheus-data", Namespace: ns},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("8Gi")},
+				},
+			},
+		}
+	)
+
+	var testCases = []struct {
+		name         string
+		status       map[string]interface{}
+		expected     error
+		expectedSize string
+	}{
+		{
+			name:         "ready",
+			status:       map[string]interface{}{"readyToUse": true, "restoreSize": "10Gi"},
+			expectedSize: "10Gi",
+		},
+		{
+			name:         "ready without restore size",
+			status:       map[string]interface{}{"readyToUse": true},
+			expectedSize: "8Gi",
+		},
+		{
+			name:     "failed",
+			status:   map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "snapshot controller failed"}},
+			expected: errSnapshotFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var deleted string
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(apiruntime.NewScheme())
+			dynamicClient.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+				// simulate the snapshot controller taking the snapshot. the
+				// snapshot is then saved by the default reactor.
+				snapshot := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+				return false, nil, unstructured.SetNestedField(snapshot.Object, tc.status, "status")
+			})
+			dynamicClient.PrependReactor("delete", "volumesnapshots", func(action k8stesting.Action) (bool, apiruntime.Object, error) {
+				deleted = action.(k8stesting.DeleteAction).GetName()
+				return false, nil, nil
+			})
+
+			defaultDynamicClient := newDynamicClient
+			newDynamicClient = func(*rest.Config) (dynamic.Interface, error) {
+				return dynamicClient, nil
+			}
+			defer func() {
+				newDynamicClient = defaultDynamicClient
+			}()
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", ns)
+			testConfig.Set("request-timeout", "10s")
+
+			k8sClientset := k8sfake.NewSimpleClientset(source)
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sClientset,
+			}
+
+			snapshot, err := clientset.CreateVolumeSnapshot(context.Background(), "prometheus-data", "csi-hostpath-snapclass")
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if tc.expected != nil {
+				if deleted == "" {
+					t.Error("expected failed volume snapshot to be deleted")
+				}
+				return
+			}
+
+			created, err := dynamicClient.Resource(volumeSnapshotResource).Namespace(ns).Get(context.Background(), snapshot, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual, _, _ := unstructured.NestedString(created.Object, "spec", "source", "persistentVolumeClaimName"); actual != "prometheus-data" {
+				t.Errorf("mismatch source claim. expected: prometheus-data, actual: %s", actual)
+			}
+
+			if actual, _, _ := unstructured.NestedString(created.Object, "spec", "volumeSnapshotClassName"); actual != "csi-hostpath-snapclass" {
+				t.Errorf("mismatch volume snapshot class. expected: csi-hostpath-snapclass, actual: %s", actual)
+			}
+
+			claimName, err := clientset.CreateClaimFromSnapshot(context.Background(), "prometheus-data", snapshot)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			claim, err := k8sClientset.CoreV1().PersistentVolumeClaims(ns).Get(context.Background(), claimName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if dataSource := claim.Spec.DataSource; dataSource == nil || dataSource.Kind != "VolumeSnapshot" || dataSource.Name != snapshot {
+				t.Errorf("mismatch data source. expected: VolumeSnapshot %s, actual: %+v", snapshot, dataSource)
+			}
+
+			if actual := claim.Spec.StorageClassName; actual == nil || *actual != storageClass {
+				t.Errorf("mismatch storage class. expected: %s, actual: %v", storageClass, actual)
+			}
+
+			size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+			if expected := resource.MustParse(tc.expectedSize); size.Cmp(expected) != 0 {
+				t.Errorf("mismatch size. expected: %s, actual: %s", expected.String(), size.String())
+			}
+
+			if err := clientset.DeleteClaim(context.Background(), claimName); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if err := clientset.DeleteVolumeSnapshot(context.Background(), snapshot); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if deleted != snapshot {
+				t.Errorf("mismatch deleted volume snapshot. expected: %s, actual: %s", snapshot, deleted)
+			}
+		})
+	}
+}
+
+func TestDataDirClaim(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-0", Namespace: "test-ns"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "prometheus",
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "config", MountPath: "/etc/prometheus"},
+						{Name: "storage", MountPath: "/prometheus", SubPath: "prometheus-db"},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+				{Name: "storage", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "prometheus-db"},
+				}},
+			},
+		},
+	}
+
+	var testCases = []struct {
+		dataDir         string
+		expected        error
+		expectedClaim   string
+		expectedSubPath string
+	}{
+		{dataDir: "/prometheus", expectedClaim: "prometheus-db", expectedSubPath: "prometheus-db"},
+		{dataDir: "/prometheus/data/", expectedClaim: "prometheus-db", expectedSubPath: "prometheus-db/data"},
+		{dataDir: "/etc/prometheus", expected: errNoDataDirClaim},
+		{dataDir: "/prometheus-data", expected: errNoDataDirClaim},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.dataDir, func(t *testing.T) {
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("pod", "prometheus-0")
+			testConfig.Set("container", "prometheus")
+			testConfig.Set("request-timeout", "10s")
+
+			clientset := &Clientset{
+				testConfig,
+				&rest.Config{},
+				log.New("debug", io.Discard),
+				k8sfake.NewSimpleClientset(pod),
+			}
+
+			claim, subPath, err := clientset.DataDirClaim(tc.dataDir)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors: expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if claim != tc.expectedClaim || subPath != tc.expectedSubPath {
+				t.Errorf("mismatch claim. expected: %s (%s), actual: %s (%s)", tc.expectedClaim, tc.expectedSubPath, claim, subPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+var (
+	errConnectionClosed = fmt.Errorf("connection closed")
+
+	// streamCloseTimeout is how long to wait for an exec stream to return,
+	// after its connections are closed.
+	streamCloseTimeout = 5 * time.Second
+)
+
+// streamWithContext streams with exec until ctx is done. Executor doesn't
+// support contexts in client-go v0.20, so the connections of exec are closed
+// instead, if it implements io.Closer. Closing the connections doesn't stop
+// the remote process. Nothing is written to the output streams after
+// streamWithContext returns.
+func streamWithContext(ctx context.Context, exec remotecommand.Executor, options remotecommand.StreamOptions) error {
+	var stdout, stderr *gatedWriter
+	if options.Stdout != nil {
+		stdout = &gatedWriter{w: options.Stdout}
+		options.Stdout = stdout
+	}
+	if options.Stderr != nil {
+		stderr = &gatedWriter{w: options.Stderr}
+		options.Stderr = stderr
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- exec.Stream(options)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	if closer, ok := exec.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	// wait for the stream to stop writing to the output streams. if it doesn't
+	// return in time, the output streams are closed off instead.
+	select {
+	case <-errCh:
+	case <-time.After(streamCloseTimeout):
+		stdout.close()
+		stderr.close()
+	}
+
+	return ctx.Err()
+}
+
+// gatedWriter is an io.Writer which stops writing to w once it's closed.
+type gatedWriter struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Write writes p to w, unless the writer is closed.
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return 0, errConnectionClosed
+	}
+	return g.w.Write(p)
+}
+
+// close closes the writer. It waits for any in-progress write to finish.
+func (g *gatedWriter) close() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+}
+
+// closableExecutor is a remotecommand.Executor whose connections can be
+// closed.
+type closableExecutor struct {
+	remotecommand.Executor
+	io.Closer
+}
+
+// connTracker is a spdy.Upgrader which keeps track of the upgraded
+// connections, so that they can be closed.
+type connTracker struct {
+	spdy.Upgrader
+
+	mu     sync.Mutex
+	conns  []httpstream.Connection
+	closed bool
+}
+
+// NewConnection upgrades resp, and keeps track of the new connection.
+func (t *connTracker) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := t.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		_ = conn.Close()
+		return nil, errConnectionClosed
+	}
+
+	t.conns = append(t.conns, conn)
+	return conn, nil
+}
+
+// Close closes all the connections, including those that are upgraded later.
+func (t *connTracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, conn := range t.conns {
+		_ = conn.Close()
+	}
+	return nil
+}
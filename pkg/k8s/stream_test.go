@@ -0,0 +1,55 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// stuckExecutor is a remotecommand.Executor whose stream doesn't return when
+// ctx is done. It writes to stdout once it's released.
+type stuckExecutor struct {
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (e *stuckExecutor) Stream(options remotecommand.StreamOptions) error {
+	defer close(e.done)
+
+	<-e.release
+	_, err := options.Stdout.Write([]byte("late"))
+	return err
+}
+
+func TestStreamWithContext(t *testing.T) {
+	original := streamCloseTimeout
+	streamCloseTimeout = 10 * time.Millisecond
+	defer func() {
+		streamCloseTimeout = original
+	}()
+
+	exec := &stuckExecutor{
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stdout bytes.Buffer
+	err := streamWithContext(ctx, exec, remotecommand.StreamOptions{Stdout: &stdout})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error: %v, actual: %v", context.Canceled, err)
+	}
+
+	close(exec.release)
+	<-exec.done
+
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output after return, actual: %q", stdout.String())
+	}
+}
@@ -0,0 +1,405 @@
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/ihcsim/promdump/pkg/log"
+)
+
+const (
+	// channelProtocolV4 is the binary channel protocol of the exec
+	// subresource, over WebSocket. There is no way to signal the end of stdin.
+	channelProtocolV4 = "v4.channel.k8s.io"
+
+	// channelProtocolV5 extends channelProtocolV4 with the close signal, which
+	// is used to signal the end of stdin.
+	channelProtocolV5 = "v5.channel.k8s.io"
+
+	stdinChannel  byte = 0
+	stdoutChannel byte = 1
+	stderrChannel byte = 2
+	errorChannel  byte = 3
+	closeChannel  byte = 255
+
+	// websocketBufferSize is the size of the messages sent on the stdin
+	// channel.
+	websocketBufferSize = 32 * 1024
+)
+
+var (
+	errUnsupportedProtocol = fmt.Errorf("unsupported channel protocol")
+	errStreamClosed        = fmt.Errorf("exec stream closed before the remote command exited")
+	errStdinUnsupported    = fmt.Errorf("channel protocol can't signal the end of stdin")
+)
+
+// webSocketExecutor implements the remotecommand.Executor interface with the
+// channel protocol of the exec subresource, over WebSocket. It's used when
+// SPDY upgrades are blocked, e.g. by proxies. Each message is prefixed with the
+// number of its channel (stdin, stdout, stderr or error).
+type webSocketExecutor struct {
+	config *rest.Config
+	method string
+	url    *url.URL
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	closed bool
+}
+
+// newWebSocketExecutor returns a WebSocket executor, whose connection is
+// closed by its Close() method.
+var newWebSocketExecutor = func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
+	return &webSocketExecutor{
+		config: config,
+		method: method,
+		url:    url,
+	}, nil
+}
+
+// Stream opens a WebSocket connection to the exec subresource, and copies the
+// standard streams over it, until the remote command exits. A non-zero exit
+// code is returned as utilexec.CodeExitError. TTY isn't supported. Stdin
+// requires the v5 protocol, as the remote command would never see its end
+// otherwise.
+func (e *webSocketExecutor) Stream(options remotecommand.StreamOptions) error {
+	conn, err := e.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	protocol := conn.Subprotocol()
+	if protocol != channelProtocolV4 && protocol != channelProtocolV5 {
+		return fmt.Errorf("%w: %q", errUnsupportedProtocol, protocol)
+	}
+
+	if options.Stdin != nil && protocol != channelProtocolV5 {
+		return fmt.Errorf("%w: %s. upgrade the API server, or use the spdy exec transport", errStdinUnsupported, protocol)
+	}
+
+	var writeMu sync.Mutex
+	write := func(channel byte, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+	}
+
+	// a failed read of stdin closes the connection, so that the remote
+	// command doesn't wait for the rest of it
+	stdinErr := make(chan error, 1)
+	if options.Stdin != nil {
+		go func() {
+			buf := make([]byte, websocketBufferSize)
+			for {
+				n, err := options.Stdin.Read(buf)
+				if n > 0 {
+					if err := write(stdinChannel, buf[:n]); err != nil {
+						return
+					}
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					stdinErr <- err
+					_ = conn.Close()
+					return
+				}
+			}
+
+			_ = write(closeChannel, []byte{stdinChannel})
+		}()
+	}
+
+	var (
+		status        = &bytes.Buffer{}
+		statusWritten bool
+	)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case err := <-stdinErr:
+				return fmt.Errorf("can't read stdin: %w", err)
+			default:
+			}
+
+			if statusWritten || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				break
+			}
+
+			if e.isClosed() {
+				return errStreamClosed
+			}
+			return err
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		channel, data := message[0], message[1:]
+		var w io.Writer
+		switch channel {
+		case stdoutChannel:
+			w = options.Stdout
+		case stderrChannel:
+			w = options.Stderr
+		case errorChannel:
+			w, statusWritten = status, true
+		}
+
+		if w == nil || len(data) == 0 {
+			continue
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return decodeStatus(status.Bytes())
+}
+
+// Close closes the WebSocket connection.
+func (e *webSocketExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	if e.conn != nil {
+		return e.conn.Close()
+	}
+	return nil
+}
+
+func (e *webSocketExecutor) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+// dial upgrades the exec request to a WebSocket connection. The request is
+// sent through the round trippers of the rest config, so that it's
+// authenticated like any other request.
+func (e *webSocketExecutor) dial() (*websocket.Conn, error) {
+	tlsConfig, err := rest.TLSConfigFor(e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if e.config.Proxy != nil {
+		proxy = e.config.Proxy
+	}
+
+	upgrader := &websocketRoundTripper{
+		dialer: &websocket.Dialer{
+			Proxy:           proxy,
+			TLSClientConfig: tlsConfig,
+			Subprotocols:    []string{channelProtocolV5, channelProtocolV4},
+		},
+	}
+	wrapper, err := rest.HTTPWrappersForConfig(e.config, upgrader)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(e.method, e.url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := wrapper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		_ = upgrader.conn.Close()
+		return nil, errStreamClosed
+	}
+	e.conn = upgrader.conn
+
+	return upgrader.conn, nil
+}
+
+// websocketRoundTripper is a http.RoundTripper which upgrades requests to
+// WebSocket connections.
+type websocketRoundTripper struct {
+	dialer *websocket.Dialer
+	conn   *websocket.Conn
+}
+
+// RoundTrip upgrades req to a WebSocket connection. A failed upgrade is
+// returned as an error.
+func (rt *websocketRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	conn, resp, err := rt.dialer.DialContext(req.Context(), u.String(), req.Header)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			return nil, apierrors.NewGenericServerResponse(resp.StatusCode, req.Method, schema.GroupResource{Resource: "pods"}, "", string(body), 0, false)
+		}
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	rt.conn = conn
+	return resp, nil
+}
+
+// decodeStatus decodes the status written to the error channel, like the v4
+// stream protocol of client-go does.
+func decodeStatus(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	status := metav1.Status{}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("error stream protocol error: %v in %q", err, string(data))
+	}
+
+	switch status.Status {
+	case metav1.StatusSuccess:
+		return nil
+	case metav1.StatusFailure:
+		if status.Reason == remotecommandconsts.NonZeroExitCodeReason && status.Details != nil {
+			for _, cause := range status.Details.Causes {
+				if cause.Type != remotecommandconsts.ExitCodeCauseType {
+					continue
+				}
+
+				var code int
+				if _, err := fmt.Sscanf(cause.Message, "%d", &code); err != nil {
+					return fmt.Errorf("error stream protocol error: invalid exit code value %q", cause.Message)
+				}
+
+				return utilexec.CodeExitError{
+					Err:  fmt.Errorf("command terminated with non-zero exit code: %v", status.Message),
+					Code: code,
+				}
+			}
+		}
+		return fmt.Errorf("error executing remote command: %s", status.Message)
+	default:
+		return fmt.Errorf("error stream protocol error: unknown error %q", status.Status)
+	}
+}
+
+// fallbackExecutor streams over SPDY, and falls back to WebSocket if the SPDY
+// upgrade fails. An upgrade failure happens before any data is streamed, so
+// the exec request can be sent again safely.
+type fallbackExecutor struct {
+	config *rest.Config
+	url    *url.URL
+	logger *log.Logger
+
+	mu      sync.Mutex
+	current remotecommand.Executor
+	closed  bool
+}
+
+// Stream streams over SPDY, or over WebSocket if the SPDY upgrade fails.
+func (e *fallbackExecutor) Stream(options remotecommand.StreamOptions) error {
+	spdyExec, err := newExecutor(e.config, "POST", e.url)
+	if err != nil {
+		return err
+	}
+
+	if err := e.setCurrent(spdyExec); err != nil {
+		return err
+	}
+
+	err = spdyExec.Stream(options)
+	if !isUpgradeFailure(err) {
+		return err
+	}
+
+	_ = level.Warn(e.logger).Log("message", "SPDY upgrade failed, falling back to WebSocket",
+		"reason", err)
+
+	wsExec, err := newWebSocketExecutor(e.config, "GET", e.url)
+	if err != nil {
+		return err
+	}
+
+	if err := e.setCurrent(wsExec); err != nil {
+		return err
+	}
+
+	return wsExec.Stream(options)
+}
+
+// Close closes the connections of the current executor.
+func (e *fallbackExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	if closer, ok := e.current.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *fallbackExecutor) setCurrent(exec remotecommand.Executor) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return errStreamClosed
+	}
+	e.current = exec
+	return nil
+}
+
+// isUpgradeFailure returns true if err is returned by a failed SPDY upgrade,
+// i.e. either the request couldn't be sent, or a proxy responded without
+// switching protocols. The errors of the API server (e.g. forbidden, or pod
+// not found) are returned as API statuses, and aren't upgrade failures, unless
+// the server requires another protocol.
+func isUpgradeFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code == http.StatusUpgradeRequired
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "unable to upgrade connection") ||
+		strings.Contains(msg, "error sending request")
+}
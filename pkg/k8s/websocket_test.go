@@ -0,0 +1,263 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/ihcsim/promdump/pkg/config"
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/spf13/viper"
+)
+
+func TestWebSocketExec(t *testing.T) {
+	success := metav1.Status{Status: metav1.StatusSuccess}
+	nonZeroExitCode := metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: "command terminated with exit code 2",
+		Reason:  remotecommandconsts.NonZeroExitCodeReason,
+		Details: &metav1.StatusDetails{
+			Causes: []metav1.StatusCause{
+				{Type: remotecommandconsts.ExitCodeCauseType, Message: "2"},
+			},
+		},
+	}
+	errRead := errors.New("read failed")
+
+	var testCases = []struct {
+		name           string
+		transport      string
+		protocols      []string
+		stdin          io.Reader
+		status         metav1.Status
+		expectedStdout string
+		expectedCode   int
+		expectedErr    error
+	}{
+		{
+			name:           "v5 with stdin",
+			transport:      ExecTransportWebSocket,
+			protocols:      []string{channelProtocolV5, channelProtocolV4},
+			stdin:          strings.NewReader("dump"),
+			status:         success,
+			expectedStdout: "dump",
+		},
+		{
+			name:           "v4 without stdin",
+			transport:      ExecTransportWebSocket,
+			protocols:      []string{channelProtocolV4},
+			status:         success,
+			expectedStdout: "hello",
+		},
+		{
+			name:        "v4 with stdin",
+			transport:   ExecTransportWebSocket,
+			protocols:   []string{channelProtocolV4},
+			stdin:       strings.NewReader("dump"),
+			status:      success,
+			expectedErr: errStdinUnsupported,
+		},
+		{
+			name:        "stdin read error",
+			transport:   ExecTransportWebSocket,
+			protocols:   []string{channelProtocolV5, channelProtocolV4},
+			stdin:       iotest.ErrReader(errRead),
+			status:      success,
+			expectedErr: errRead,
+		},
+		{
+			name:           "non-zero exit code",
+			transport:      ExecTransportWebSocket,
+			protocols:      []string{channelProtocolV4},
+			status:         nonZeroExitCode,
+			expectedStdout: "hello",
+			expectedCode:   2,
+		},
+		{
+			name:           "auto fallback",
+			transport:      ExecTransportAuto,
+			protocols:      []string{channelProtocolV5, channelProtocolV4},
+			stdin:          strings.NewReader("dump"),
+			status:         success,
+			expectedStdout: "dump",
+		},
+	}
+
+	// use the real SPDY executor, so that the auto transport falls back to
+	// WebSocket
+	defer func(orig func(*rest.Config, string, *url.URL) (remotecommand.Executor, error)) {
+		newExecutor = orig
+	}(newExecutor)
+	newExecutor = newSPDYExecutor
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(channelHandler(t, tc.protocols, tc.status))
+			defer srv.Close()
+
+			k8sConfig := &rest.Config{Host: srv.URL}
+			k8sClientset, err := kubernetes.NewForConfig(k8sConfig)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			testConfig := &config.Config{Viper: viper.New()}
+			testConfig.Set("namespace", "test-ns")
+			testConfig.Set("pod", "test-pod")
+			testConfig.Set("container", "test-container")
+			testConfig.Set("request-timeout", "5s")
+			testConfig.Set("exec-transport", tc.transport)
+
+			clientset := &Clientset{
+				testConfig,
+				k8sConfig,
+				log.New("debug", io.Discard),
+				k8sClientset,
+			}
+
+			var stdout, stderr bytes.Buffer
+			err = clientset.ExecPod(context.Background(), []string{"cat"}, tc.stdin, &stdout, &stderr, false)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Errorf("mismatch error. expected: %v, actual: %v", tc.expectedErr, err)
+				}
+				return
+			}
+
+			if tc.expectedCode != 0 {
+				var exitErr utilexec.CodeExitError
+				if !errors.As(err, &exitErr) || exitErr.ExitStatus() != tc.expectedCode {
+					t.Fatalf("expected exit code %d, actual: %v", tc.expectedCode, err)
+				}
+			} else if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual := stdout.String(); actual != tc.expectedStdout {
+				t.Errorf("mismatch stdout. expected: %q, actual: %q", tc.expectedStdout, actual)
+			}
+
+			if actual := stderr.String(); actual != "warning" {
+				t.Errorf("mismatch stderr. expected: %q, actual: %q", "warning", actual)
+			}
+		})
+	}
+}
+
+// channelHandler returns a handler which speaks the channel protocol of the
+// exec subresource. It echoes stdin to stdout until the close signal, or
+// writes "hello" to stdout if the exec request has no stdin. SPDY upgrades are
+// rejected. The client may close the connection early, so the errors of the
+// connection are ignored.
+func channelHandler(t *testing.T, protocols []string, status metav1.Status) http.HandlerFunc {
+	upgrader := websocket.Upgrader{Subprotocols: protocols}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			http.Error(w, "upgrade to SPDY isn't supported", http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error("unexpected error: ", err)
+			return
+		}
+		defer conn.Close()
+
+		write := func(channel byte, data []byte) {
+			_ = conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+		}
+
+		write(stderrChannel, []byte("warning"))
+		if r.URL.Query().Get("stdin") != "true" {
+			write(stdoutChannel, []byte("hello"))
+		} else {
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+
+				if message[0] == closeChannel {
+					break
+				}
+				write(stdoutChannel, message[1:])
+			}
+		}
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			t.Error("unexpected error: ", err)
+			return
+		}
+		write(errorChannel, data)
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+}
+
+func TestIsUpgradeFailure(t *testing.T) {
+	podsResource := schema.GroupResource{Resource: "pods"}
+
+	var testCases = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:     "proxy rejection",
+			err:      errors.New("unable to upgrade connection: 400 Bad Request"),
+			expected: true,
+		},
+		{
+			name:     "handshake failure",
+			err:      errors.New("error sending request: EOF"),
+			expected: true,
+		},
+		{
+			name:     "upgrade required",
+			err:      apierrors.NewGenericServerResponse(http.StatusUpgradeRequired, "POST", podsResource, "test-pod", "", 0, false),
+			expected: true,
+		},
+		{
+			name: "forbidden",
+			err:  apierrors.NewForbidden(podsResource, "test-pod", errors.New("no RBAC policy matched")),
+		},
+		{
+			name: "pod not found",
+			err:  apierrors.NewNotFound(podsResource, "test-pod"),
+		},
+		{
+			name: "exit code",
+			err:  utilexec.CodeExitError{Err: errors.New("command terminated with non-zero exit code"), Code: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isUpgradeFailure(tc.err); actual != tc.expected {
+				t.Errorf("mismatch upgrade failure of %v. expected: %t, actual: %t", tc.err, tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Package progress reports the progress of the transfers of promdump, e.g. of
+// data dumps and uploads, on a terminal.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/status"
+)
+
+// Interval is the min interval between two reports.
+const Interval = 500 * time.Millisecond
+
+// IsTerminal returns true if f is a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Reporter reports the progress of a transfer to w. Every report overwrites
+// the previous one, on a single line. The bytes transferred are counted by the
+// readers and writers returned by Reader and Writer. The progress of the dump
+// reported by the promdump binary is added with Update.
+type Reporter struct {
+	w     io.Writer
+	label string
+	now   func() time.Time
+
+	mu       sync.Mutex
+	bytes    int64
+	total    int64
+	remote   *status.Progress
+	start    time.Time
+	reported time.Time
+	width    int
+}
+
+// New returns a new Reporter which writes to w. label prefixes the reports.
+func New(w io.Writer, label string) *Reporter {
+	return &Reporter{w: w, label: label, now: time.Now, start: time.Now()}
+}
+
+// WithTotal sets the number of bytes to transfer, used to estimate the
+// remaining time.
+func (r *Reporter) WithTotal(total int64) *Reporter {
+	r.total = total
+	return r
+}
+
+// Reader returns a reader which counts the bytes read from rd.
+func (r *Reporter) Reader(rd io.Reader) io.Reader {
+	return &reader{rd, r}
+}
+
+// Writer returns a writer which counts the bytes written to w.
+func (r *Reporter) Writer(w io.Writer) io.Writer {
+	return &writer{w, r}
+}
+
+// Add adds n bytes to the bytes transferred.
+func (r *Reporter) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bytes += n
+	r.report(false)
+}
+
+// Set sets the bytes transferred, and the number of bytes to transfer. Its
+// signature matches download.ProgressFunc.
+func (r *Reporter) Set(bytes, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bytes, r.total = bytes, total
+	r.report(false)
+}
+
+// Update sets the progress reported by the promdump binary. The files read
+// from the data directory are used to estimate the remaining time if the
+// number of bytes to transfer is unknown, e.g. because the dump is compressed.
+func (r *Reporter) Update(p status.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.remote = &p
+	r.report(false)
+}
+
+// Done writes the final report, and ends its line. Nothing is written if
+// nothing was reported, e.g. if nothing was transferred.
+func (r *Reporter) Done() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reported.IsZero() {
+		return
+	}
+
+	r.report(true)
+	_, _ = fmt.Fprintln(r.w)
+}
+
+// report writes the report if the last one is older than Interval, or if
+// force is true. The caller must hold the lock.
+func (r *Reporter) report(force bool) {
+	now := r.now()
+	if !force && now.Sub(r.reported) < Interval {
+		return
+	}
+	r.reported = now
+
+	line := r.line(now)
+
+	// clear the end of the previous report, if it's longer
+	padding := ""
+	if n := r.width - len(line); n > 0 {
+		padding = strings.Repeat(" ", n)
+	}
+	r.width = len(line)
+
+	_, _ = fmt.Fprintf(r.w, "\r%s%s", line, padding)
+}
+
+func (r *Reporter) line(now time.Time) string {
+	var (
+		elapsed = now.Sub(r.start)
+		fields  = []string{formatBytes(r.bytes)}
+	)
+	if r.total > 0 {
+		fields[0] = fmt.Sprintf("%s/%s", formatBytes(r.bytes), formatBytes(r.total))
+	}
+
+	if r.remote != nil {
+		fields = append(fields, fmt.Sprintf("%d/%d files", r.remote.Files, r.remote.TotalFiles))
+		if r.remote.Block != "" {
+			fields = append(fields, "block "+r.remote.Block)
+		}
+	}
+
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fields = append(fields, formatBytes(int64(float64(r.bytes)/seconds))+"/s")
+	}
+
+	if eta, ok := r.eta(elapsed); ok {
+		fields = append(fields, "ETA "+eta.Round(time.Second).String())
+	}
+
+	return fmt.Sprintf("%s: %s", r.label, strings.Join(fields, ", "))
+}
+
+// eta estimates the remaining time from the ratio of bytes transferred, or
+// of bytes read by the promdump binary.
+func (r *Reporter) eta(elapsed time.Duration) (time.Duration, bool) {
+	var done, total int64
+	switch {
+	case r.total > 0:
+		done, total = r.bytes, r.total
+	case r.remote != nil && r.remote.TotalBytes > 0:
+		done, total = r.remote.Bytes, r.remote.TotalBytes
+	}
+
+	if done <= 0 || total <= 0 {
+		return 0, false
+	}
+
+	if done >= total {
+		return 0, true
+	}
+
+	return time.Duration(float64(elapsed) * float64(total-done) / float64(done)), true
+}
+
+// formatBytes formats n in binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type reader struct {
+	r io.Reader
+	p *Reporter
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(int64(n))
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	p *Reporter
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.p.Add(int64(n))
+	return n, err
+}
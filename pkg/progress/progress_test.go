@@ -0,0 +1,84 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/status"
+)
+
+func TestReporter(t *testing.T) {
+	var (
+		start = time.Date(2021, 4, 18, 20, 0, 0, 0, time.UTC)
+		now   = start
+	)
+
+	var testCases = []struct {
+		name     string
+		total    int64
+		remote   *status.Progress
+		expected string
+	}{
+		{
+			name:     "upload",
+			total:    4 << 20,
+			expected: "upload: 1.0 MiB/4.0 MiB, 512.0 KiB/s, ETA 6s",
+		},
+		{
+			name:     "dump",
+			remote:   &status.Progress{Bytes: 30 << 20, TotalBytes: 40 << 20, Files: 3, TotalFiles: 4, Block: "01F3XHV1CVT7VYSCB1RW5KQX8Z"},
+			expected: "dump: 1.0 MiB, 3/4 files, block 01F3XHV1CVT7VYSCB1RW5KQX8Z, 512.0 KiB/s, ETA 1s",
+		},
+		{
+			name:     "unknown total",
+			expected: "unknown total: 1.0 MiB, 512.0 KiB/s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = start
+
+			buf := &bytes.Buffer{}
+			reporter := New(buf, tc.name).WithTotal(tc.total)
+			reporter.now = func() time.Time { return now }
+			reporter.start = start
+
+			if tc.remote != nil {
+				reporter.Update(*tc.remote)
+			}
+
+			now = start.Add(2 * time.Second)
+			if _, err := io.Copy(reporter.Writer(io.Discard), bytes.NewReader(make([]byte, 1<<20))); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			reporter.Done()
+
+			reports := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\r")
+			if actual := strings.TrimSpace(reports[len(reports)-1]); actual != tc.expected {
+				t.Errorf("mismatch report. expected: %q, actual: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	var testCases = []struct {
+		n        int64
+		expected string
+	}{
+		{n: 0, expected: "0 B"},
+		{n: 1023, expected: "1023 B"},
+		{n: 1536, expected: "1.5 KiB"},
+		{n: 5 << 30, expected: "5.0 GiB"},
+	}
+
+	for _, tc := range testCases {
+		if actual := formatBytes(tc.n); actual != tc.expected {
+			t.Errorf("mismatch bytes. expected: %s, actual: %s", tc.expected, actual)
+		}
+	}
+}
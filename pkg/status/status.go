@@ -0,0 +1,247 @@
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// FramePrefix prefixes the status frames written by the promdump binary to
+// stderr. Each frame is a JSON object on a single line.
+const FramePrefix = "promdump-status: "
+
+// The exit codes of the promdump binary.
+const (
+	CodeOK                   = 0
+	CodeFailure              = 1
+	CodeInvalidArgs          = 2
+	CodeIntegrityCheckFailed = 3
+	CodeIncompleteArchive    = 4
+)
+
+var (
+	// ErrFailure is returned when the promdump binary fails.
+	ErrFailure = fmt.Errorf("promdump failed")
+
+	// ErrInvalidArgs is returned when the promdump binary rejects its
+	// arguments.
+	ErrInvalidArgs = fmt.Errorf("invalid arguments")
+
+	// ErrIntegrityCheckFailed is returned when the integrity check of the
+	// TSDB fails.
+	ErrIntegrityCheckFailed = fmt.Errorf("integrity check failed")
+
+	// ErrIncompleteArchive is returned when the data dump is incomplete.
+	ErrIncompleteArchive = fmt.Errorf("incomplete archive")
+
+	// ErrNoStatus is returned when the promdump binary exits without writing
+	// its final status frame, e.g. when the exec stream is interrupted.
+	ErrNoStatus = fmt.Errorf("promdump exited without status")
+)
+
+// Frame is the status of the promdump binary. The last frame is written when
+// the binary exits. The frames written before it report the progress of the
+// operation.
+type Frame struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+
+	// Bytes is the number of bytes written to stdout.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Progress is set in the progress frames only.
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// Progress is the progress of a dump, in terms of the files read from the
+// data directory.
+type Progress struct {
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+	Files      int   `json:"files"`
+	TotalFiles int   `json:"totalFiles"`
+
+	// Block is the ULID of the block being read, or head for the head block
+	// and the WAL.
+	Block string `json:"block,omitempty"`
+}
+
+// Write writes the frame f to w.
+func Write(w io.Writer, f Frame) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s%s\n", FramePrefix, data)
+	return err
+}
+
+// Error is a non-zero exit code of the promdump binary, with the message of
+// its status frame.
+type Error struct {
+	Code    int
+	Message string
+
+	// err is the error returned by the exec request.
+	err error
+}
+
+// Error returns the message and the exit code.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s with exit code %d", codeError(e.Code), e.Code)
+	}
+	return fmt.Sprintf("%s with exit code %d: %s", codeError(e.Code), e.Code, e.Message)
+}
+
+// Is matches the sentinel error of the exit code.
+func (e *Error) Is(target error) bool {
+	return target == codeError(e.Code)
+}
+
+// Unwrap returns the error returned by the exec request.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func codeError(code int) error {
+	switch code {
+	case CodeInvalidArgs:
+		return ErrInvalidArgs
+	case CodeIntegrityCheckFailed:
+		return ErrIntegrityCheckFailed
+	case CodeIncompleteArchive:
+		return ErrIncompleteArchive
+	default:
+		return ErrFailure
+	}
+}
+
+// Filter is an io.Writer which removes the status frames from the stderr
+// stream of the promdump binary. The other lines are written to the
+// underlying writer.
+type Filter struct {
+	w        io.Writer
+	progress func(Progress)
+
+	mu    sync.Mutex
+	buf   []byte
+	frame *Frame
+}
+
+// NewFilter returns a new Filter which writes to w.
+func NewFilter(w io.Writer) *Filter {
+	return &Filter{w: w}
+}
+
+// WithProgress calls fn with the progress of every progress frame. The
+// progress frames are never returned by Frame.
+func (f *Filter) WithProgress(fn func(Progress)) *Filter {
+	f.progress = fn
+	return f
+}
+
+// Write writes the complete lines of p which aren't status frames to the
+// underlying writer. Incomplete lines are buffered.
+func (f *Filter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := f.buf[:i+1]
+		if err := f.writeLine(line); err != nil {
+			return 0, err
+		}
+		f.buf = f.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the buffered incomplete line.
+func (f *Filter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.buf) == 0 {
+		return nil
+	}
+
+	err := f.writeLine(f.buf)
+	f.buf = nil
+	return err
+}
+
+// Frame returns the last status frame, or nil if there is none.
+func (f *Filter) Frame() *Frame {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.frame
+}
+
+func (f *Filter) writeLine(line []byte) error {
+	if !bytes.HasPrefix(line, []byte(FramePrefix)) {
+		_, err := f.w.Write(line)
+		return err
+	}
+
+	frame := &Frame{}
+	data := strings.TrimSpace(string(line[len(FramePrefix):]))
+	if err := json.Unmarshal([]byte(data), frame); err != nil {
+		// not a frame; forward it as is
+		_, err := f.w.Write(line)
+		return err
+	}
+
+	if frame.Progress != nil {
+		if f.progress != nil {
+			f.progress(*frame.Progress)
+		}
+		return nil
+	}
+
+	f.frame = frame
+	return nil
+}
+
+// Check returns the typed error of the exec request of the promdump binary,
+// based on the error err returned by the request and the last status frame.
+// Non-zero exit codes are returned as *Error. Other errors, e.g. of the
+// connection, are returned as is.
+func Check(err error, frame *Frame) error {
+	var exitErr utilexec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+
+	if err != nil {
+		statusErr := &Error{Code: exitErr.ExitStatus(), err: err}
+		if frame != nil {
+			statusErr.Message = frame.Message
+		}
+		return statusErr
+	}
+
+	if frame == nil {
+		return ErrNoStatus
+	}
+
+	if frame.Code != CodeOK {
+		return &Error{Code: frame.Code, Message: frame.Message}
+	}
+
+	return nil
+}
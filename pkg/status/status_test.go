@@ -0,0 +1,137 @@
+package status
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestFilter(t *testing.T) {
+	var (
+		buf      = &bytes.Buffer{}
+		progress []Progress
+		filter   = NewFilter(buf).WithProgress(func(p Progress) { progress = append(progress, p) })
+	)
+
+	frame := &bytes.Buffer{}
+	if err := Write(frame, Frame{Progress: &Progress{Bytes: 512, TotalBytes: 1024, Files: 1, TotalFiles: 2, Block: "head"}}); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if err := Write(frame, Frame{Code: CodeIncompleteArchive, Message: "failed to read data file", Bytes: 42}); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	// the frame is split across writes
+	stream := "level=warn message=\"No persistent blocks found\"\n" + frame.String() + "partial line"
+	for i := 0; i < len(stream); i += 7 {
+		end := i + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		if _, err := filter.Write([]byte(stream[i:end])); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	}
+	if err := filter.Flush(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	expected := "level=warn message=\"No persistent blocks found\"\npartial line"
+	if actual := buf.String(); actual != expected {
+		t.Errorf("mismatch output. expected: %q, actual: %q", expected, actual)
+	}
+
+	expectedFrame := Frame{Code: CodeIncompleteArchive, Message: "failed to read data file", Bytes: 42}
+	if actual := filter.Frame(); actual == nil || *actual != expectedFrame {
+		t.Errorf("mismatch frame. expected: %+v, actual: %+v", expectedFrame, actual)
+	}
+
+	expectedProgress := Progress{Bytes: 512, TotalBytes: 1024, Files: 1, TotalFiles: 2, Block: "head"}
+	if len(progress) != 1 || progress[0] != expectedProgress {
+		t.Errorf("mismatch progress. expected: %+v, actual: %+v", expectedProgress, progress)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var (
+		connErr = fmt.Errorf("connection reset by peer")
+		exitErr = func(code int) error {
+			return fmt.Errorf("failed to exec command: %w", utilexec.CodeExitError{Err: fmt.Errorf("exit"), Code: code})
+		}
+	)
+
+	var testCases = []struct {
+		name         string
+		err          error
+		frame        *Frame
+		expected     error
+		expectedCode int
+	}{
+		{
+			name:  "success",
+			frame: &Frame{Code: CodeOK},
+		},
+		{
+			name:     "no status",
+			expected: ErrNoStatus,
+		},
+		{
+			name:     "connection error",
+			err:      connErr,
+			expected: connErr,
+		},
+		{
+			name:         "invalid arguments",
+			err:          exitErr(CodeInvalidArgs),
+			frame:        &Frame{Code: CodeInvalidArgs, Message: "invalid arguments: min-time cannot exceed max-time"},
+			expected:     ErrInvalidArgs,
+			expectedCode: CodeInvalidArgs,
+		},
+		{
+			name:         "integrity check failed",
+			err:          exitErr(CodeIntegrityCheckFailed),
+			frame:        &Frame{Code: CodeIntegrityCheckFailed},
+			expected:     ErrIntegrityCheckFailed,
+			expectedCode: CodeIntegrityCheckFailed,
+		},
+		{
+			name:         "incomplete archive",
+			err:          exitErr(CodeIncompleteArchive),
+			expected:     ErrIncompleteArchive,
+			expectedCode: CodeIncompleteArchive,
+		},
+		{
+			name:         "killed",
+			err:          exitErr(137),
+			expected:     ErrFailure,
+			expectedCode: 137,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(tc.err, tc.frame)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("mismatch errors. expected: %v, actual: %v", tc.expected, err)
+			}
+
+			if tc.expectedCode == 0 {
+				return
+			}
+
+			var statusErr *Error
+			if !errors.As(err, &statusErr) || statusErr.Code != tc.expectedCode {
+				t.Fatalf("expected exit code %d, actual: %v", tc.expectedCode, err)
+			}
+
+			// the exit error remains available, so that it isn't retried
+			var exitErr utilexec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Errorf("expected exit error to be wrapped, actual: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// Package timerange parses the time expressions of the time range of a dump,
+// and normalizes them to UTC.
+package timerange
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// Now is the expression of the current time.
+	Now = "now"
+
+	// DefaultWindow is the default window of the range around a time.
+	DefaultWindow = "30m"
+
+	displayLayout = "2006-01-02 15:04:05 MST"
+)
+
+var (
+	errInvalidExpression = fmt.Errorf("invalid time expression")
+	errInvalidDuration   = fmt.Errorf("invalid duration")
+	errInvalidRange      = fmt.Errorf("invalid time range")
+
+	// layouts are the accepted layouts of absolute times. The times without
+	// offsets are in the location of the time zone option.
+	layouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+)
+
+// Parse parses the time expression expr, and returns it in UTC. expr is one
+// of:
+//
+//   - an RFC3339 time, with or without offset, e.g. 2021-04-18T20:00:00+02:00
+//   - a time without offset, e.g. 2021-04-18 20:00:00, 2021-04-18 20:00 or
+//     2021-04-18, in the location loc
+//   - a Unix timestamp in seconds, milliseconds, microseconds or nanoseconds,
+//     e.g. 1618768800 or 1618768800.5
+//   - a relative time, e.g. now, now-6h, now+30m or -2h, relative to now
+func Parse(expr string, now time.Time, loc *time.Location) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return time.Time{}, fmt.Errorf("%w: empty", errInvalidExpression)
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	if t, ok, err := parseRelative(expr, now); ok {
+		return t.UTC(), err
+	}
+
+	if t, ok := parseUnix(expr); ok {
+		return t.UTC(), nil
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, expr, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q. expected an RFC3339 time, a Unix timestamp, or a relative time like now-6h", errInvalidExpression, expr)
+}
+
+// parseRelative parses the relative times now, now±<duration> and
+// -<duration>. ok is false if expr isn't a relative time.
+func parseRelative(expr string, now time.Time) (t time.Time, ok bool, err error) {
+	offset := expr
+	switch {
+	case expr == Now:
+		return now, true, nil
+	case strings.HasPrefix(expr, Now+"-"), strings.HasPrefix(expr, Now+"+"):
+		offset = strings.TrimPrefix(expr, Now)
+	case strings.HasPrefix(expr, "-"):
+	default:
+		return time.Time{}, false, nil
+	}
+
+	// negative Unix timestamps aren't relative times
+	if _, err := strconv.ParseFloat(offset, 64); err == nil {
+		return time.Time{}, false, nil
+	}
+
+	d, err := ParseDuration(offset[1:])
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("%w: %q: %s", errInvalidExpression, expr, err)
+	}
+
+	if offset[0] == '-' {
+		d = -d
+	}
+
+	return now.Add(d), true, nil
+}
+
+// parseUnix parses Unix timestamps. Their unit is derived from their
+// magnitude. ok is false if expr isn't a number.
+func parseUnix(expr string) (time.Time, bool) {
+	if strings.ContainsAny(expr, "eE") {
+		return time.Time{}, false
+	}
+
+	if i, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		abs := i
+		if abs < 0 {
+			abs = -abs
+		}
+
+		switch {
+		case abs < 1e11:
+			return time.Unix(i, 0), true
+		case abs < 1e14:
+			return time.UnixMilli(i), true
+		case abs < 1e17:
+			return time.UnixMicro(i), true
+		default:
+			return time.Unix(0, i), true
+		}
+	}
+
+	// fractional timestamps are in seconds
+	f, err := strconv.ParseFloat(expr, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return time.Time{}, false
+	}
+
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))), true
+}
+
+// ParseDuration parses positive durations, like 90m, 1h30m or 2d.
+func ParseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		// the Prometheus durations support days, weeks and years
+		md, mErr := model.ParseDuration(s)
+		if mErr != nil {
+			return 0, fmt.Errorf("%w: %q", errInvalidDuration, s)
+		}
+		d = time.Duration(md)
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("%w: %q must be positive", errInvalidDuration, s)
+	}
+
+	return d, nil
+}
+
+// Range is a time range in UTC.
+type Range struct {
+	Min time.Time
+	Max time.Time
+}
+
+// String returns the range, with its duration.
+func (r Range) String() string {
+	return fmt.Sprintf("%s to %s (%s)", r.Min.Format(displayLayout), r.Max.Format(displayLayout), r.Max.Sub(r.Min).Round(time.Second))
+}
+
+// Options are the options of a time range. Empty options aren't set. At most
+// one of MinTime/MaxTime, Since and Around can be set.
+type Options struct {
+	MinTime string
+	MaxTime string
+
+	// Since is the duration of the range ending now.
+	Since string
+
+	// Around is the time at the center of the range. The range spans Window
+	// before and after it, and ends at most now.
+	Around string
+	Window string
+
+	// TZ is the time zone of the times without offsets. It defaults to UTC.
+	TZ string
+}
+
+// Resolve returns the time range of opts, relative to now. defaultMin and
+// defaultMax are the time expressions of the min and max times if none of the
+// options are set.
+func Resolve(opts Options, now time.Time, defaultMin, defaultMax string) (Range, error) {
+	loc := time.UTC
+	if opts.TZ != "" {
+		var err error
+		if loc, err = time.LoadLocation(opts.TZ); err != nil {
+			return Range{}, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+
+	var (
+		absolute = opts.MinTime != "" || opts.MaxTime != ""
+		since    = opts.Since != ""
+		around   = opts.Around != ""
+	)
+	if count(absolute, since, around) > 1 {
+		return Range{}, fmt.Errorf("%w: min/max times, since and around are mutually exclusive", errInvalidRange)
+	}
+
+	if opts.Window != "" && !around {
+		return Range{}, fmt.Errorf("%w: window requires around", errInvalidRange)
+	}
+
+	var r Range
+	switch {
+	case since:
+		d, err := ParseDuration(opts.Since)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid since: %w", err)
+		}
+		r = Range{Min: now.Add(-d).UTC(), Max: now.UTC()}
+
+	case around:
+		center, err := Parse(opts.Around, now, loc)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid around: %w", err)
+		}
+
+		window := opts.Window
+		if window == "" {
+			window = DefaultWindow
+		}
+		d, err := ParseDuration(window)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid window: %w", err)
+		}
+
+		r = Range{Min: center.Add(-d), Max: center.Add(d)}
+		if r.Max.After(now) {
+			r.Max = now.UTC()
+		}
+
+	default:
+		minExpr, maxExpr := opts.MinTime, opts.MaxTime
+		if minExpr == "" {
+			minExpr = defaultMin
+		}
+		if maxExpr == "" {
+			maxExpr = defaultMax
+		}
+
+		var err error
+		if r.Min, err = Parse(minExpr, now, loc); err != nil {
+			return Range{}, fmt.Errorf("invalid min time: %w", err)
+		}
+		if r.Max, err = Parse(maxExpr, now, loc); err != nil {
+			return Range{}, fmt.Errorf("invalid max time: %w", err)
+		}
+	}
+
+	return r, Validate(r, now)
+}
+
+// Validate returns an error if the min time of r is after its max time, or
+// if any of them is after now.
+func Validate(r Range, now time.Time) error {
+	now = now.UTC()
+
+	if r.Min.After(r.Max) {
+		return fmt.Errorf("%w: min time (%s) cannot be after max time (%s)", errInvalidRange, r.Min.Format(displayLayout), r.Max.Format(displayLayout))
+	}
+
+	if r.Min.After(now) {
+		return fmt.Errorf("%w: min time (%s) cannot be after now (%s)", errInvalidRange, r.Min.Format(displayLayout), now.Format(displayLayout))
+	}
+
+	if r.Max.After(now) {
+		return fmt.Errorf("%w: max time (%s) cannot be after now (%s)", errInvalidRange, r.Max.Format(displayLayout), now.Format(displayLayout))
+	}
+
+	return nil
+}
+
+func count(values ...bool) int {
+	var n int
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
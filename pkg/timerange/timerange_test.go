@@ -0,0 +1,134 @@
+package timerange
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	var (
+		now    = time.Date(2021, 4, 18, 20, 0, 0, 0, time.UTC)
+		berlin = time.FixedZone("CEST", 2*60*60)
+	)
+
+	var testCases = []struct {
+		expr      string
+		loc       *time.Location
+		expected  time.Time
+		expectErr error
+	}{
+		{expr: "2021-04-18 18:00:00", expected: time.Date(2021, 4, 18, 18, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18 18:00:00", loc: berlin, expected: time.Date(2021, 4, 18, 16, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18 18:00", loc: berlin, expected: time.Date(2021, 4, 18, 16, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18", expected: time.Date(2021, 4, 18, 0, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18T18:00:00+02:00", expected: time.Date(2021, 4, 18, 16, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18T18:00:00Z", loc: berlin, expected: time.Date(2021, 4, 18, 18, 0, 0, 0, time.UTC)},
+		{expr: "2021-04-18T18:00:00.5-01:00", expected: time.Date(2021, 4, 18, 19, 0, 0, 5e8, time.UTC)},
+		{expr: "1618768800", expected: time.Date(2021, 4, 18, 18, 0, 0, 0, time.UTC)},
+		{expr: "1618768800.25", expected: time.Date(2021, 4, 18, 18, 0, 0, 25e7, time.UTC)},
+		{expr: "1618768800123", expected: time.Date(2021, 4, 18, 18, 0, 0, 123e6, time.UTC)},
+		{expr: "1618768800123456789", expected: time.Date(2021, 4, 18, 18, 0, 0, 123456789, time.UTC)},
+		{expr: "now", expected: now},
+		{expr: "now-6h", expected: now.Add(-6 * time.Hour)},
+		{expr: "now+30m", expected: now.Add(30 * time.Minute)},
+		{expr: "now-1d", expected: now.Add(-24 * time.Hour)},
+		{expr: "-2h", expected: now.Add(-2 * time.Hour)},
+		{expr: "-1h30m", expected: now.Add(-90 * time.Minute)},
+		{expr: "now-", expectErr: errInvalidExpression},
+		{expr: "now-6x", expectErr: errInvalidExpression},
+		{expr: "yesterday", expectErr: errInvalidExpression},
+		{expr: "", expectErr: errInvalidExpression},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			actual, err := Parse(tc.expr, now, tc.loc)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("mismatch errors. expected: %v, actual: %v", tc.expectErr, err)
+			}
+
+			if !actual.Equal(tc.expected) || (err == nil && actual.Location() != time.UTC) {
+				t.Errorf("mismatch time. expected: %s, actual: %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Date(2021, 4, 18, 20, 0, 0, 0, time.UTC)
+
+	var testCases = []struct {
+		name      string
+		opts      Options
+		expected  Range
+		expectErr error
+	}{
+		{
+			name:     "defaults",
+			expected: Range{Min: now.Add(-time.Hour), Max: now},
+		},
+		{
+			name:     "min time",
+			opts:     Options{MinTime: "now-6h"},
+			expected: Range{Min: now.Add(-6 * time.Hour), Max: now},
+		},
+		{
+			name:     "time zone",
+			opts:     Options{MinTime: "2021-04-18 18:00:00", MaxTime: "2021-04-18 19:00:00", TZ: "Europe/Berlin"},
+			expected: Range{Min: time.Date(2021, 4, 18, 16, 0, 0, 0, time.UTC), Max: time.Date(2021, 4, 18, 17, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "since",
+			opts:     Options{Since: "3h"},
+			expected: Range{Min: now.Add(-3 * time.Hour), Max: now},
+		},
+		{
+			name:     "around",
+			opts:     Options{Around: "2021-04-18 18:00", Window: "15m"},
+			expected: Range{Min: time.Date(2021, 4, 18, 17, 45, 0, 0, time.UTC), Max: time.Date(2021, 4, 18, 18, 15, 0, 0, time.UTC)},
+		},
+		{
+			name:     "around now",
+			opts:     Options{Around: "now-10m"},
+			expected: Range{Min: now.Add(-40 * time.Minute), Max: now},
+		},
+		{
+			name:      "since and min time",
+			opts:      Options{Since: "3h", MinTime: "now-6h"},
+			expectErr: errInvalidRange,
+		},
+		{
+			name:      "window without around",
+			opts:      Options{Window: "30m"},
+			expectErr: errInvalidRange,
+		},
+		{
+			name:      "min time after max time",
+			opts:      Options{MinTime: "now-1h", MaxTime: "now-2h"},
+			expectErr: errInvalidRange,
+		},
+		{
+			name:      "max time after now",
+			opts:      Options{MaxTime: "now+1h"},
+			expectErr: errInvalidRange,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Resolve(tc.opts, now, "now-1h", Now)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("mismatch errors. expected: %v, actual: %v", tc.expectErr, err)
+			}
+			if tc.expectErr != nil {
+				return
+			}
+
+			if !actual.Min.Equal(tc.expected.Min) || !actual.Max.Equal(tc.expected.Max) {
+				t.Errorf("mismatch range. expected: %s, actual: %s", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package tsdb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+const (
+	alertsMetric         = "ALERTS"
+	alertsForStateMetric = "ALERTS_FOR_STATE"
+	alertNameLabel       = "alertname"
+	alertStateLabel      = "alertstate"
+	alertStateFiring     = "firing"
+
+	// AlertGap is the max gap between the samples of a firing alert within
+	// the same incident. It exceeds the usual rule evaluation intervals, so
+	// that missed evaluations, e.g. during a restart of Prometheus, don't
+	// split an incident.
+	AlertGap = 10 * time.Minute
+)
+
+// Incident is an interval during which an alert was firing. The intervals of
+// all the series of the alert which overlap, or are less than AlertGap apart,
+// form one incident.
+type Incident struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// NumSeries is the number of firing series of the alert, e.g. one per
+	// instance.
+	NumSeries int `json:"numSeries"`
+}
+
+// alertSample is a sample of the ALERTS series of a firing alert. A stale
+// sample marks the resolution of the alert.
+type alertSample struct {
+	t     int64
+	stale bool
+}
+
+// activeAt is a sample of the ALERTS_FOR_STATE series of an alert. Its value
+// is the time the alert became active, i.e. pending.
+type activeAt struct {
+	t     int64
+	value int64
+}
+
+// alertSeries contains the samples of one series of an alert, identified by
+// its labels.
+type alertSeries struct {
+	firing   []alertSample
+	activeAt []activeAt
+}
+
+// AlertIncidents returns the incidents of the alert name between minTimeNano
+// and maxTimeNano, sorted by start time. The firing intervals are read from
+// the ALERTS series of the persistent blocks and of the head block. The start
+// of an interval is moved back to the active time of the alert found in the
+// ALERTS_FOR_STATE series, if any, so that it includes the pending period.
+func (t *Tsdb) AlertIncidents(name string, minTimeNano, maxTimeNano int64) ([]Incident, error) {
+	var (
+		minTime = milliseconds(minTimeNano)
+		maxTime = milliseconds(maxTimeNano)
+		series  = map[string]*alertSeries{}
+	)
+	_ = level.Debug(t.logger).Log("message", "looking for alert incidents",
+		"datadir", t.dataDir,
+		"alert", name,
+		"minTime", time.Unix(0, minTimeNano).UTC(),
+		"maxTime", time.Unix(0, maxTimeNano).UTC())
+
+	add := func(lset labels.Labels, ts int64, v float64) {
+		if ts < minTime || ts > maxTime || lset.Get(alertNameLabel) != name {
+			return
+		}
+
+		key := labels.NewBuilder(lset).Del(labels.MetricName, alertStateLabel).Labels().String()
+		s, ok := series[key]
+		if !ok {
+			s = &alertSeries{}
+		}
+
+		switch lset.Get(labels.MetricName) {
+		case alertsMetric:
+			if lset.Get(alertStateLabel) != alertStateFiring {
+				return
+			}
+			s.firing = append(s.firing, alertSample{t: ts, stale: value.IsStaleNaN(v)})
+		case alertsForStateMetric:
+			if value.IsStaleNaN(v) {
+				return
+			}
+			s.activeAt = append(s.activeAt, activeAt{t: ts, value: int64(v * 1000)})
+		default:
+			return
+		}
+		series[key] = s
+	}
+
+	if err := t.readAlertBlocks(name, minTime, maxTime, add); err != nil {
+		return nil, err
+	}
+
+	if err := t.readAlertHead(add); err != nil {
+		return nil, err
+	}
+
+	incidents := mergeIncidents(series)
+	_ = level.Debug(t.logger).Log("message", "finish looking for alert incidents",
+		"alert", name,
+		"numSeries", len(series),
+		"numIncidents", len(incidents))
+
+	return incidents, nil
+}
+
+// readAlertBlocks calls fn with the samples of the alert series of the
+// persistent blocks which overlap with the time range.
+func (t *Tsdb) readAlertBlocks(name string, minTime, maxTime int64, fn func(labels.Labels, int64, float64)) error {
+	blocks, err := t.db.Blocks()
+	if err != nil {
+		return err
+	}
+
+	matchers := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, alertsMetric+"|"+alertsForStateMetric),
+		labels.MustNewMatcher(labels.MatchEqual, alertNameLabel, name),
+	}
+
+	for _, block := range blocks {
+		meta := block.Meta()
+		if meta.MaxTime < minTime || meta.MinTime > maxTime {
+			continue
+		}
+
+		querier, err := tsdb.NewBlockQuerier(block, minTime, maxTime)
+		if err != nil {
+			return err
+		}
+
+		seriesSet := querier.Select(false, nil, matchers...)
+		for seriesSet.Next() {
+			var (
+				s  = seriesSet.At()
+				it = s.Iterator()
+			)
+			for it.Next() {
+				ts, v := it.At()
+				fn(s.Labels(), ts, v)
+			}
+
+			if err := it.Err(); err != nil {
+				_ = querier.Close()
+				return err
+			}
+		}
+
+		if err := seriesSet.Err(); err != nil {
+			_ = querier.Close()
+			return err
+		}
+
+		if err := querier.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readAlertHead calls fn with the samples of the alert series of the head
+// block, read from the WAL.
+func (t *Tsdb) readAlertHead(fn func(labels.Labels, int64, float64)) error {
+	minValidTime, err := t.headMinValidTime()
+	if err != nil {
+		return err
+	}
+
+	var (
+		decoder record.Decoder
+		series  = map[uint64]labels.Labels{}
+	)
+	return t.readHead(func(rec []byte) error {
+		switch decoder.Type(rec) {
+		case record.Series:
+			refs, err := decoder.Series(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				switch ref.Labels.Get(labels.MetricName) {
+				case alertsMetric, alertsForStateMetric:
+					series[ref.Ref] = ref.Labels
+				}
+			}
+
+		case record.Samples:
+			samples, err := decoder.Samples(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, sample := range samples {
+				lset, ok := series[sample.Ref]
+				if !ok || sample.T < minValidTime {
+					continue
+				}
+				fn(lset, sample.T, sample.V)
+			}
+		}
+		return nil
+	})
+}
+
+// firingInterval is a firing interval of an alert series, in milliseconds.
+type firingInterval struct {
+	key   string
+	start int64
+	end   int64
+}
+
+// mergeIncidents splits the samples of every series into firing intervals,
+// and merges the intervals of all the series into incidents.
+func mergeIncidents(series map[string]*alertSeries) []Incident {
+	var (
+		gap       = AlertGap.Milliseconds()
+		intervals []firingInterval
+	)
+	for key, s := range series {
+		sort.Slice(s.firing, func(i, j int) bool { return s.firing[i].t < s.firing[j].t })
+
+		var current *firingInterval
+		for _, sample := range s.firing {
+			switch {
+			case sample.stale:
+				// the alert is resolved
+				if current != nil {
+					intervals = append(intervals, *current)
+					current = nil
+				}
+			case current != nil && sample.t-current.end > gap:
+				intervals = append(intervals, *current)
+				current = &firingInterval{key: key, start: sample.t, end: sample.t}
+			case current != nil:
+				current.end = sample.t
+			default:
+				current = &firingInterval{key: key, start: sample.t, end: sample.t}
+			}
+		}
+		if current != nil {
+			intervals = append(intervals, *current)
+		}
+	}
+
+	// include the pending periods
+	for i, in := range intervals {
+		for _, a := range series[in.key].activeAt {
+			if a.t >= in.start && a.t <= in.end && a.value > 0 && a.value < intervals[i].start {
+				intervals[i].start = a.value
+			}
+		}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		if intervals[i].start == intervals[j].start {
+			return intervals[i].key < intervals[j].key
+		}
+		return intervals[i].start < intervals[j].start
+	})
+
+	var (
+		incidents = []Incident{}
+		start     int64
+		end       int64
+		keys      map[string]struct{}
+	)
+	flush := func() {
+		if keys == nil {
+			return
+		}
+		incidents = append(incidents, Incident{
+			Start:     time.Unix(0, nanoseconds(start)).UTC(),
+			End:       time.Unix(0, nanoseconds(end)).UTC(),
+			NumSeries: len(keys),
+		})
+	}
+
+	for _, in := range intervals {
+		if keys == nil || in.start-end > gap {
+			flush()
+			start, end, keys = in.start, in.end, map[string]struct{}{}
+		}
+
+		if in.end > end {
+			end = in.end
+		}
+		keys[in.key] = struct{}{}
+	}
+	flush()
+
+	return incidents
+}
@@ -0,0 +1,163 @@
+package tsdb
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	promtsdb "github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+func TestAlertIncidents(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "promdump-alerts-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		logger  = log.New("debug", io.Discard)
+		minTime = unix("2021-04-01 00:00:00 UTC", time.Millisecond, t)
+		stale   = math.Float64frombits(value.StaleNaN)
+		minute  = time.Minute.Milliseconds()
+
+		firing = func(instance string) labels.Labels {
+			return labels.FromStrings(labels.MetricName, "ALERTS", "alertname", "HighErrorRate", "alertstate", "firing", "instance", instance)
+		}
+		pending = func(instance string) labels.Labels {
+			return labels.FromStrings(labels.MetricName, "ALERTS", "alertname", "HighErrorRate", "alertstate", "pending", "instance", instance)
+		}
+		forState = func(instance string) labels.Labels {
+			return labels.FromStrings(labels.MetricName, "ALERTS_FOR_STATE", "alertname", "HighErrorRate", "instance", instance)
+		}
+		other = labels.FromStrings(labels.MetricName, "ALERTS", "alertname", "InstanceDown", "alertstate", "firing", "instance", "a")
+	)
+
+	// persistent block, between 00:00 and 02:00:
+	//   - instance a fires between 00:10 and 00:30, and is active since 00:05
+	//   - instance b fires between 00:20 and 00:40
+	//   - instance a is pending between 01:00 and 01:05
+	//   - another alert fires at 01:30
+	var samples []*promtsdb.MetricSample
+	for m := int64(10); m <= 30; m++ {
+		ts := minTime + m*minute
+		samples = append(samples,
+			&promtsdb.MetricSample{TimestampMs: ts, Value: 1, Labels: firing("a")},
+			&promtsdb.MetricSample{TimestampMs: ts, Value: float64((minTime + 5*minute) / 1000), Labels: forState("a")})
+	}
+	samples = append(samples, &promtsdb.MetricSample{TimestampMs: minTime + 31*minute, Value: stale, Labels: firing("a")})
+	for m := int64(20); m <= 40; m++ {
+		samples = append(samples, &promtsdb.MetricSample{TimestampMs: minTime + m*minute, Value: 1, Labels: firing("b")})
+	}
+	for m := int64(60); m <= 65; m++ {
+		samples = append(samples, &promtsdb.MetricSample{TimestampMs: minTime + m*minute, Value: 1, Labels: pending("a")})
+	}
+	samples = append(samples, &promtsdb.MetricSample{TimestampMs: minTime + 90*minute, Value: 1, Labels: other})
+
+	if _, err := promtsdb.CreateBlock(samples, tempDir, minTime, minTime+120*minute, logger.Logger); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	// head block: instance a fires between 03:00 and 03:15, and between 03:40
+	// and 03:45, after a gap
+	w, err := wal.New(nil, nil, filepath.Join(tempDir, "wal"), false)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	var (
+		encoder record.Encoder
+		head    []record.RefSample
+	)
+	for _, m := range []int64{180, 185, 190, 195, 220, 225} {
+		head = append(head, record.RefSample{Ref: 1, T: minTime + m*minute, V: 1})
+	}
+	records := [][]byte{
+		encoder.Series([]record.RefSeries{{Ref: 1, Labels: firing("a")}}, nil),
+		encoder.Samples(head, nil),
+	}
+	if err := w.Log(records...); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	tsdb, err := New(tempDir, logger)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer tsdb.Close()
+
+	at := func(m int64) time.Time {
+		return time.Unix(0, nanoseconds(minTime+m*minute)).UTC()
+	}
+
+	var testCases = []struct {
+		name     string
+		minTime  int64
+		maxTime  int64
+		expected []Incident
+	}{
+		{
+			name:    "all",
+			minTime: 0,
+			maxTime: 240,
+			expected: []Incident{
+				{Start: at(5), End: at(40), NumSeries: 2},
+				{Start: at(180), End: at(195), NumSeries: 1},
+				{Start: at(220), End: at(225), NumSeries: 1},
+			},
+		},
+		{
+			name:    "head block",
+			minTime: 120,
+			maxTime: 240,
+			expected: []Incident{
+				{Start: at(180), End: at(195), NumSeries: 1},
+				{Start: at(220), End: at(225), NumSeries: 1},
+			},
+		},
+		{
+			name:    "partial incident",
+			minTime: 35,
+			maxTime: 190,
+			expected: []Incident{
+				{Start: at(35), End: at(40), NumSeries: 1},
+				{Start: at(180), End: at(190), NumSeries: 1},
+			},
+		},
+		{
+			name:    "no incidents",
+			minTime: 50,
+			maxTime: 170,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tsdb.AlertIncidents("HighErrorRate", nanoseconds(minTime+tc.minTime*minute), nanoseconds(minTime+tc.maxTime*minute))
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("mismatch incidents. expected: %v, actual: %v", tc.expected, actual)
+			}
+
+			for i, expected := range tc.expected {
+				if !actual[i].Start.Equal(expected.Start) || !actual[i].End.Equal(expected.End) || actual[i].NumSeries != expected.NumSeries {
+					t.Errorf("mismatch incident %d. expected: %+v, actual: %+v", i, expected, actual[i])
+				}
+			}
+		})
+	}
+}
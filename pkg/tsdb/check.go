@@ -0,0 +1,295 @@
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// CheckResult is the outcome of the integrity check of one part of the data
+// directory, like a persistent block or the WAL.
+type CheckResult struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// String returns a one-line summary of the result.
+func (r CheckResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("FAIL  %s: %s", r.Name, r.Err)
+	}
+
+	return fmt.Sprintf("OK    %s: %s", r.Name, r.Detail)
+}
+
+// CheckFailed returns true if any of the results has an error.
+func CheckFailed(results []CheckResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies the integrity of the data directory. It opens the index,
+// chunks and tombstones of every persistent block, verifying the chunks'
+// checksums and the index postings. It also ensures that the WAL segments and
+// head chunk files are contiguous. Corruption is reported in the results; the
+// returned error is only used when the data directory can't be read.
+func (t *Tsdb) Check() ([]CheckResult, error) {
+	dirs, err := blockDirs(t.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CheckResult
+	for _, dir := range dirs {
+		_ = level.Debug(t.logger).Log("message", "checking block", "path", dir)
+		detail, err := t.checkBlock(dir)
+		results = append(results, CheckResult{
+			Name:   "block " + filepath.Base(dir),
+			Detail: detail,
+			Err:    err,
+		})
+	}
+
+	detail, err := t.checkWAL()
+	results = append(results, CheckResult{Name: "wal", Detail: detail, Err: err})
+
+	detail, err = t.checkHeadChunks()
+	results = append(results, CheckResult{Name: "chunks_head", Detail: detail, Err: err})
+
+	return results, nil
+}
+
+func (t *Tsdb) checkBlock(dir string) (string, error) {
+	block, err := tsdb.OpenBlock(t.logger, dir, nil)
+	if err != nil {
+		return "", fmt.Errorf("can't open block: %w", err)
+	}
+	defer block.Close()
+
+	indexReader, err := block.Index()
+	if err != nil {
+		return "", fmt.Errorf("can't open index: %w", err)
+	}
+	defer indexReader.Close()
+
+	chunkReader, err := block.Chunks()
+	if err != nil {
+		return "", fmt.Errorf("can't open chunks: %w", err)
+	}
+	defer chunkReader.Close()
+
+	tombstoneReader, err := block.Tombstones()
+	if err != nil {
+		return "", fmt.Errorf("can't open tombstones: %w", err)
+	}
+	defer tombstoneReader.Close()
+
+	postings, err := indexReader.Postings(index.AllPostingsKey())
+	if err != nil {
+		return "", fmt.Errorf("can't read postings: %w", err)
+	}
+
+	var (
+		meta      = block.Meta()
+		lastRef   uint64
+		numSeries uint64
+		numChunks uint64
+		lset      labels.Labels
+		chks      []chunks.Meta
+	)
+	for postings.Next() {
+		ref := postings.At()
+		if numSeries > 0 && ref <= lastRef {
+			return "", fmt.Errorf("postings aren't sorted: series %d found after series %d", ref, lastRef)
+		}
+		lastRef = ref
+
+		if err := indexReader.Series(ref, &lset, &chks); err != nil {
+			return "", fmt.Errorf("can't read series %d: %w", ref, err)
+		}
+
+		for i, chk := range chks {
+			if chk.MinTime > chk.MaxTime {
+				return "", fmt.Errorf("chunk %d of series %s has min time %d after max time %d", chk.Ref, lset, chk.MinTime, chk.MaxTime)
+			}
+
+			if chk.MinTime < meta.MinTime || chk.MaxTime > meta.MaxTime {
+				return "", fmt.Errorf("chunk %d of series %s is outside of the block time range", chk.Ref, lset)
+			}
+
+			if i > 0 && chk.MinTime <= chks[i-1].MaxTime {
+				return "", fmt.Errorf("chunks %d and %d of series %s overlap", chks[i-1].Ref, chk.Ref, lset)
+			}
+
+			// the chunk reader verifies the chunk's checksum
+			chunk, err := chunkReader.Chunk(chk.Ref)
+			if err != nil {
+				return "", fmt.Errorf("can't read chunk %d of series %s: %w", chk.Ref, lset, err)
+			}
+
+			it := chunk.Iterator(nil)
+			for it.Next() {
+			}
+			if err := it.Err(); err != nil {
+				return "", fmt.Errorf("can't decode chunk %d of series %s: %w", chk.Ref, lset, err)
+			}
+
+			numChunks++
+		}
+		numSeries++
+	}
+	if err := postings.Err(); err != nil {
+		return "", fmt.Errorf("can't read postings: %w", err)
+	}
+
+	if meta.Stats.NumSeries > 0 && meta.Stats.NumSeries != numSeries {
+		return "", fmt.Errorf("mismatch number of series. meta.json: %d, index: %d", meta.Stats.NumSeries, numSeries)
+	}
+
+	var numTombstones int
+	if err := tombstoneReader.Iter(func(ref uint64, intervals tombstones.Intervals) error {
+		if err := indexReader.Series(ref, &lset, &chks); err != nil {
+			return fmt.Errorf("can't find series %d of tombstone: %w", ref, err)
+		}
+
+		for _, interval := range intervals {
+			if interval.Mint > interval.Maxt {
+				return fmt.Errorf("tombstone of series %s has min time %d after max time %d", lset, interval.Mint, interval.Maxt)
+			}
+		}
+
+		numTombstones++
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d series, %d chunks, %d tombstones", numSeries, numChunks, numTombstones), nil
+}
+
+func (t *Tsdb) checkWAL() (string, error) {
+	dir := filepath.Join(t.dataDir, "wal")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "no WAL found", nil
+	}
+
+	first, last, err := wal.Segments(dir)
+	if err != nil {
+		return "", err
+	}
+
+	checkpoint, checkpointIndex, err := wal.LastCheckpoint(dir)
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		return "", fmt.Errorf("can't read checkpoints: %w", err)
+	}
+
+	var numRecords int
+	if checkpoint != "" {
+		// the segments following the checkpoint must all be present
+		if first > checkpointIndex+1 {
+			return "", fmt.Errorf("missing segments %08d to %08d after %s", checkpointIndex+1, first-1, filepath.Base(checkpoint))
+		}
+
+		n, err := t.readWAL(checkpoint, -1, false, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", filepath.Base(checkpoint), err)
+		}
+		numRecords += n
+	}
+
+	if first < 0 {
+		return fmt.Sprintf("no segments, %d records", numRecords), nil
+	}
+
+	n, err := t.readWAL(dir, -1, true, nil)
+	if err != nil {
+		return "", err
+	}
+	numRecords += n
+
+	return fmt.Sprintf("segments %08d - %08d, %d records", first, last, numRecords), nil
+}
+
+// readWAL reads the records of the segments in dir, starting from the segment
+// from (or the first segment, if from is negative), returning the number of
+// records read. The checksum of every record is verified. If fn isn't nil, it
+// is called with every record. If tolerateTail is true, a corrupted record in
+// the last segment is ignored, because it is likely a torn write of a running
+// Prometheus, which Prometheus repairs on start-up.
+func (t *Tsdb) readWAL(dir string, from int, tolerateTail bool, fn func(rec []byte) error) (int, error) {
+	first, last, err := wal.Segments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if first < 0 || last < from {
+		return 0, nil
+	}
+
+	segments, err := wal.NewSegmentsRangeReader(wal.SegmentRange{Dir: dir, First: from, Last: -1})
+	if err != nil {
+		return 0, err
+	}
+	defer segments.Close()
+
+	var (
+		reader     = wal.NewReader(segments)
+		numRecords int
+	)
+	for reader.Next() {
+		numRecords++
+		if fn == nil {
+			continue
+		}
+
+		if err := fn(reader.Record()); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := reader.Err(); err != nil {
+		var corruption *wal.CorruptionErr
+		if tolerateTail && errors.As(err, &corruption) && corruption.Segment == last {
+			_ = level.Warn(t.logger).Log("message", "ignoring corrupted tail of the last WAL segment",
+				"segment", last,
+				"reason", err)
+			return numRecords, nil
+		}
+		return 0, err
+	}
+
+	return numRecords, nil
+}
+
+func (t *Tsdb) checkHeadChunks() (string, error) {
+	files, outOfSequence, err := t.HeadChunks()
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "no head chunk files found", nil
+	}
+
+	if len(outOfSequence) > 0 {
+		return "", fmt.Errorf("found %d out-of-sequence head chunk files, starting with %s",
+			len(outOfSequence), filepath.Base(outOfSequence[0].Path))
+	}
+
+	return fmt.Sprintf("files %06d - %06d", files[0].Seq, files[len(files)-1].Seq), nil
+}
@@ -0,0 +1,138 @@
+package tsdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ihcsim/promdump/pkg/log"
+)
+
+func TestCheck(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		corrupt  func(dataDir, blockDir string, t *testing.T)
+		expected map[string]bool
+	}{
+		{
+			name:    "valid",
+			corrupt: func(dataDir, blockDir string, t *testing.T) {},
+			expected: map[string]bool{
+				"wal":         false,
+				"chunks_head": false,
+			},
+		},
+		{
+			name: "corrupted chunk",
+			corrupt: func(dataDir, blockDir string, t *testing.T) {
+				// the last 4 bytes of the chunks file are the checksum of
+				// the last chunk
+				path := filepath.Join(blockDir, "chunks", "000001")
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0600); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+			},
+			expected: map[string]bool{
+				"wal":         false,
+				"chunks_head": false,
+			},
+		},
+		{
+			name: "missing wal segment",
+			corrupt: func(dataDir, blockDir string, t *testing.T) {
+				walDir := filepath.Join(dataDir, "wal")
+				if err := os.MkdirAll(walDir, 0755); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+				for _, file := range []string{"00000001", "00000003"} {
+					if err := os.WriteFile(filepath.Join(walDir, file), nil, 0600); err != nil {
+						t.Fatal("unexpected error: ", err)
+					}
+				}
+			},
+			expected: map[string]bool{
+				"wal":         true,
+				"chunks_head": false,
+			},
+		},
+		{
+			name: "out-of-sequence head chunks",
+			corrupt: func(dataDir, blockDir string, t *testing.T) {
+				chunksDir := filepath.Join(dataDir, "chunks_head")
+				if err := os.MkdirAll(chunksDir, 0755); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+				for _, file := range []string{"000001", "000003"} {
+					if err := os.WriteFile(filepath.Join(chunksDir, file), nil, 0600); err != nil {
+						t.Fatal("unexpected error: ", err)
+					}
+				}
+			},
+			expected: map[string]bool{
+				"wal":         false,
+				"chunks_head": true,
+			},
+		},
+	}
+
+	logger := log.New("debug", io.Discard)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "promdump-check-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			blockOne, blockTwo, err := initPersistentBlocks(tempDir, logger, t)
+			if err != nil {
+				t.Fatal("unexpected error when creating persistent blocks: ", err)
+			}
+			tc.corrupt(tempDir, blockOne, t)
+
+			tsdb, err := New(tempDir, logger)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer tsdb.Close()
+
+			results, err := tsdb.Check()
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			expected := tc.expected
+			expected["block "+filepath.Base(blockOne)] = tc.name == "corrupted chunk"
+			expected["block "+filepath.Base(blockTwo)] = false
+			if len(results) != len(expected) {
+				t.Fatalf("mismatch number of results. expected: %d, actual: %d", len(expected), len(results))
+			}
+
+			for _, result := range results {
+				failed, ok := expected[result.Name]
+				if !ok {
+					t.Errorf("unexpected result: %s", result)
+					continue
+				}
+
+				if failed != (result.Err != nil) {
+					t.Errorf("mismatch result of %s. expected failure: %t, actual: %s", result.Name, failed, result)
+				}
+			}
+
+			var anyFailed bool
+			for _, failed := range expected {
+				anyFailed = anyFailed || failed
+			}
+			if actual := CheckFailed(results); actual != anyFailed {
+				t.Errorf("mismatch check failed. expected: %t, actual: %t", anyFailed, actual)
+			}
+		})
+	}
+}
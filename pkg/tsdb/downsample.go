@@ -0,0 +1,210 @@
+package tsdb
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// DownsampleAuto keeps the last sample of every time window. Series that
+	// look like counters also keep the sample preceding a counter reset, so
+	// that functions like rate() and increase() remain accurate.
+	DownsampleAuto = "auto"
+
+	// DownsampleAggregate replaces every series with its min, max, sum and
+	// count aggregates over every time window. The aggregates are stored as
+	// separate series, identified by the AggrLabel label.
+	DownsampleAggregate = "aggregate"
+
+	// AggrLabel is the name of the label that identifies the aggregated series
+	// created by the DownsampleAggregate mode.
+	AggrLabel = "aggr"
+)
+
+var (
+	errUnsupportedDownsampleMode = fmt.Errorf("unsupported downsample mode")
+
+	counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+)
+
+// ValidateDownsampleMode returns an error if mode isn't a supported downsample
+// mode.
+func ValidateDownsampleMode(mode string) error {
+	switch mode {
+	case DownsampleAuto, DownsampleAggregate:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedDownsampleMode, mode)
+	}
+}
+
+// Downsample rewrites all the series in the data directory to the coarser
+// resolution, using the provided mode. The head block must be flushed with
+// FlushHead() first, for its series to be downsampled.
+func (t *Tsdb) Downsample(resolution time.Duration, mode string) error {
+	if err := ValidateDownsampleMode(mode); err != nil {
+		return err
+	}
+
+	_ = level.Debug(t.logger).Log("message", "downsampling series",
+		"datadir", t.dataDir,
+		"resolution", resolution,
+		"mode", mode)
+
+	window := resolution.Milliseconds()
+	if window <= 0 {
+		return fmt.Errorf("downsample resolution must be at least 1ms: %s", resolution)
+	}
+
+	appendFn := func(app storage.Appender, lset labels.Labels, it chunkenc.Iterator) error {
+		return appendLast(app, lset, it, window)
+	}
+	if mode == DownsampleAggregate {
+		appendFn = func(app storage.Appender, lset labels.Labels, it chunkenc.Iterator) error {
+			return appendAggregates(app, lset, it, window)
+		}
+	}
+
+	return t.rewrite(func(lset labels.Labels) labels.Labels { return lset }, appendFn)
+}
+
+// appendLast appends the last sample of every window to app. If the series is
+// a counter, the sample preceding every counter reset is also retained.
+func appendLast(app storage.Appender, lset labels.Labels, it chunkenc.Iterator, window int64) error {
+	var (
+		counter = isCounter(lset)
+		samples []sample
+		last    *sample
+	)
+	for it.Next() {
+		ts, v := it.At()
+		if last != nil {
+			// retain the last sample of the previous window, or the sample
+			// preceding a counter reset
+			if windowOf(ts, window) != windowOf(last.t, window) || (counter && v < last.v) {
+				samples = append(samples, *last)
+			}
+		}
+
+		last = &sample{ts, v}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if last != nil {
+		samples = append(samples, *last)
+	}
+
+	return addSamples(app, lset, samples)
+}
+
+// appendAggregates appends the min, max, sum and count aggregates of every
+// window to app, as separate series.
+func appendAggregates(app storage.Appender, lset labels.Labels, it chunkenc.Iterator, window int64) error {
+	var (
+		aggrs  = map[string][]sample{}
+		names  = []string{"min", "max", "sum", "count"}
+		curr   int64
+		last   int64
+		min    = math.Inf(1)
+		max    = math.Inf(-1)
+		sum    float64
+		count  float64
+		inited bool
+	)
+
+	flush := func() {
+		aggrs["min"] = append(aggrs["min"], sample{last, min})
+		aggrs["max"] = append(aggrs["max"], sample{last, max})
+		aggrs["sum"] = append(aggrs["sum"], sample{last, sum})
+		aggrs["count"] = append(aggrs["count"], sample{last, count})
+	}
+
+	for it.Next() {
+		ts, v := it.At()
+		if w := windowOf(ts, window); !inited || w != curr {
+			if inited {
+				flush()
+			}
+			curr, min, max, sum, count, inited = w, math.Inf(1), math.Inf(-1), 0, 0, true
+		}
+
+		last = ts
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		sum += v
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if !inited {
+		return nil
+	}
+	flush()
+
+	for _, name := range names {
+		aggrLset := labels.NewBuilder(lset).Set(AggrLabel, name).Labels()
+		if err := addSamples(app, aggrLset, aggrs[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// addSamples appends samples to app, as the series lset.
+func addSamples(app storage.Appender, lset labels.Labels, samples []sample) error {
+	var ref uint64
+	for i, s := range samples {
+		if i > 0 {
+			if err := app.AddFast(ref, s.t, s.v); err == nil {
+				continue
+			}
+		}
+
+		var err error
+		if ref, err = app.Add(lset, s.t, s.v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// windowOf returns the index of the window that ts falls into. Windows are
+// aligned to the Unix epoch.
+func windowOf(ts, window int64) int64 {
+	w := ts / window
+	if ts < 0 && ts%window != 0 {
+		w--
+	}
+	return w
+}
+
+// isCounter uses the naming conventions of Prometheus metrics to determine if
+// the series is a counter.
+func isCounter(lset labels.Labels) bool {
+	name := lset.Get(labels.MetricName)
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,136 @@
+package tsdb
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	promtsdb "github.com/prometheus/prometheus/tsdb"
+)
+
+func TestDownsample(t *testing.T) {
+	var (
+		logger  = log.New("debug", io.Discard)
+		minTime = unix("2021-04-01 00:00:00 UTC", time.Millisecond, t)
+		maxTime = unix("2021-04-01 01:00:00 UTC", time.Millisecond, t)
+		counter = labels.FromStrings(labels.MetricName, "http_requests_total")
+		gauge   = labels.FromStrings(labels.MetricName, "temperature")
+	)
+
+	var testCases = []struct {
+		mode     string
+		expected map[string]int
+	}{
+		{
+			mode: DownsampleAuto,
+			expected: map[string]int{
+				// one sample per window, plus the sample before the reset
+				counter.String(): 13,
+				gauge.String():   12,
+			},
+		},
+		{
+			mode: DownsampleAggregate,
+			expected: map[string]int{
+				labels.NewBuilder(counter).Set(AggrLabel, "min").Labels().String():   12,
+				labels.NewBuilder(counter).Set(AggrLabel, "max").Labels().String():   12,
+				labels.NewBuilder(counter).Set(AggrLabel, "sum").Labels().String():   12,
+				labels.NewBuilder(counter).Set(AggrLabel, "count").Labels().String(): 12,
+				labels.NewBuilder(gauge).Set(AggrLabel, "min").Labels().String():     12,
+				labels.NewBuilder(gauge).Set(AggrLabel, "max").Labels().String():     12,
+				labels.NewBuilder(gauge).Set(AggrLabel, "sum").Labels().String():     12,
+				labels.NewBuilder(gauge).Set(AggrLabel, "count").Labels().String():   12,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "promdump-downsample-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			// 15s scrape interval, with a counter reset at 00:32:00
+			var samples []*promtsdb.MetricSample
+			for ts, i := minTime, 0; ts < maxTime; ts, i = ts+15000, i+1 {
+				value := float64(i)
+				if ts >= minTime+32*60*1000 {
+					value = float64(i - 128)
+				}
+
+				samples = append(samples,
+					&promtsdb.MetricSample{TimestampMs: ts, Value: value, Labels: counter},
+					&promtsdb.MetricSample{TimestampMs: ts, Value: float64(i % 7), Labels: gauge})
+			}
+
+			if _, err := promtsdb.CreateBlock(samples, tempDir, minTime, maxTime, logger.Logger); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			tsdb, err := New(tempDir, logger)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer tsdb.Close()
+
+			if err := tsdb.Downsample(5*time.Minute, tc.mode); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			actual := countSamples(tempDir, logger, t)
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("mismatch series. expected: %v, actual: %v", tc.expected, actual)
+			}
+
+			for series, expected := range tc.expected {
+				if actual[series] != expected {
+					t.Errorf("mismatch number of samples of %s. expected: %d, actual: %d", series, expected, actual[series])
+				}
+			}
+		})
+	}
+}
+
+// countSamples returns the number of samples per series found in the
+// persistent blocks in dir.
+func countSamples(dir string, logger *log.Logger, t *testing.T) map[string]int {
+	dirs, err := blockDirs(dir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	results := map[string]int{}
+	for _, dir := range dirs {
+		block, err := promtsdb.OpenBlock(logger, dir, nil)
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		querier, err := promtsdb.NewBlockQuerier(block, block.MinTime(), block.MaxTime())
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		seriesSet := querier.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".*"))
+		for seriesSet.Next() {
+			series := seriesSet.At()
+			it := series.Iterator()
+			for it.Next() {
+				results[series.Labels().String()]++
+			}
+		}
+		if err := seriesSet.Err(); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		querier.Close()
+		block.Close()
+	}
+
+	return results
+}
@@ -0,0 +1,99 @@
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// HeadChunkFile describes a file in the 'chunks_head' directory.
+type HeadChunkFile struct {
+	Path string
+	Seq  int
+	Size int64
+}
+
+// HeadChunks returns all the head chunk files found in the 'chunks_head'
+// directory, sorted by their sequence numbers. Prometheus refuses to start if
+// the sequence numbers aren't contiguous. The files preceding the last gap in
+// the sequence are returned as the out-of-sequence files.
+func (t *Tsdb) HeadChunks() (files []HeadChunkFile, outOfSequence []HeadChunkFile, err error) {
+	dir := filepath.Join(t.dataDir, "chunks_head")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, HeadChunkFile{
+			Path: filepath.Join(dir, entry.Name()),
+			Seq:  int(seq),
+			Size: info.Size(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Seq < files[j].Seq
+	})
+
+	for i := len(files) - 1; i > 0; i-- {
+		if files[i].Seq != files[i-1].Seq+1 {
+			outOfSequence = files[:i]
+			break
+		}
+	}
+
+	return files, outOfSequence, nil
+}
+
+// Checkpoints returns the names of the WAL checkpoint directories.
+func (t *Tsdb) Checkpoints() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(t.dataDir, "wal"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var checkpoints []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "checkpoint.") {
+			checkpoints = append(checkpoints, entry.Name())
+		}
+	}
+
+	return checkpoints, nil
+}
+
+// WALSegments returns the range [first, last] of the WAL segments. If no
+// segments are found, first and last are -1.
+func (t *Tsdb) WALSegments() (first, last int, err error) {
+	dir := filepath.Join(t.dataDir, "wal")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return -1, -1, nil
+	}
+
+	return wal.Segments(dir)
+}
@@ -0,0 +1,81 @@
+package tsdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/promdump/pkg/log"
+)
+
+func TestHeadChunks(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		files         []string
+		expected      []int
+		outOfSequence []int
+	}{
+		{name: "empty"},
+		{
+			name:     "sequential",
+			files:    []string{"000033", "000034", "000035"},
+			expected: []int{33, 34, 35},
+		},
+		{
+			name:          "out-of-sequence",
+			files:         []string{"000027", "000029", "000033", "000034"},
+			expected:      []int{27, 29, 33, 34},
+			outOfSequence: []int{27, 29},
+		},
+	}
+
+	logger := log.New("debug", io.Discard)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "promdump-files-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			chunksDir := filepath.Join(tempDir, "chunks_head")
+			if err := os.MkdirAll(chunksDir, 0755); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			for _, file := range tc.files {
+				if err := os.WriteFile(filepath.Join(chunksDir, file), []byte{}, 0600); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+			}
+
+			tsdb, err := New(tempDir, logger)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer tsdb.Close()
+
+			files, outOfSequence, err := tsdb.HeadChunks()
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual := seqs(files); !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("mismatch head chunk files. expected: %v, actual: %v", tc.expected, actual)
+			}
+
+			if actual := seqs(outOfSequence); !reflect.DeepEqual(tc.outOfSequence, actual) {
+				t.Errorf("mismatch out-of-sequence files. expected: %v, actual: %v", tc.outOfSequence, actual)
+			}
+		})
+	}
+}
+
+func seqs(files []HeadChunkFile) []int {
+	var results []int
+	for _, file := range files {
+		results = append(results, file.Seq)
+	}
+	return results
+}
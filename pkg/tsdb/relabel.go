@@ -0,0 +1,48 @@
+package tsdb
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+// RelabelConfigs contains the Prometheus relabel_configs to be applied to the
+// series of a data dump.
+type RelabelConfigs struct {
+	Configs []*relabel.Config `yaml:"relabel_configs"`
+}
+
+// LoadRelabelConfigs reads the relabel_configs from the YAML file at filename.
+func LoadRelabelConfigs(filename string) (*RelabelConfigs, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("can't read relabel config file: %w", err)
+	}
+
+	configs := &RelabelConfigs{}
+	if err := yaml.UnmarshalStrict(data, configs); err != nil {
+		return nil, fmt.Errorf("can't parse relabel config file: %w", err)
+	}
+
+	return configs, nil
+}
+
+// Relabel rewrites all the series in the data directory with the provided
+// relabel configs. Series dropped by the configs are removed. Series that end
+// up with identical label sets are merged. The head block must be flushed with
+// FlushHead() first, for its series to be relabeled.
+func (t *Tsdb) Relabel(configs *RelabelConfigs) error {
+	_ = level.Debug(t.logger).Log("message", "relabeling series",
+		"datadir", t.dataDir,
+		"numConfigs", len(configs.Configs))
+
+	mapLabels := func(lset labels.Labels) labels.Labels {
+		return relabel.Process(lset, configs.Configs...)
+	}
+
+	return t.rewrite(mapLabels, appendAll)
+}
@@ -0,0 +1,100 @@
+package tsdb
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ihcsim/promdump/pkg/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	promtsdb "github.com/prometheus/prometheus/tsdb"
+)
+
+func TestRelabel(t *testing.T) {
+	logger := log.New("debug", io.Discard)
+	tempDir, err := os.MkdirTemp("", "promdump-relabel-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if _, _, err := initPersistentBlocks(tempDir, logger, t); err != nil {
+		t.Fatal("unexpected error when creating persistent blocks: ", err)
+	}
+
+	tsdb, err := New(tempDir, logger)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer tsdb.Close()
+
+	configs := &RelabelConfigs{
+		Configs: []*relabel.Config{
+			{
+				SourceLabels: []model.LabelName{"app"},
+				Regex:        relabel.MustNewRegexp("app-02"),
+				Action:       relabel.Drop,
+			},
+			{
+				Regex:       relabel.MustNewRegexp(".*"),
+				TargetLabel: "source_cluster",
+				Replacement: "prod-eu",
+				Action:      relabel.Replace,
+			},
+		},
+	}
+	if err := tsdb.Relabel(configs); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	actual := readSeries(tempDir, logger, t)
+	expected := []string{
+		`{app="app-00", job="tsdb", source_cluster="prod-eu"}`,
+		`{app="app-00", job="tsdb", source_cluster="prod-eu"}`,
+		`{app="app-01", job="tsdb", source_cluster="prod-eu"}`,
+		`{app="app-01", job="tsdb", source_cluster="prod-eu"}`,
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("mismatch series. expected: %v, actual: %v", expected, actual)
+	}
+}
+
+// readSeries returns the sorted label sets of all the series found in the
+// persistent blocks in dir.
+func readSeries(dir string, logger *log.Logger, t *testing.T) []string {
+	dirs, err := blockDirs(dir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	var results []string
+	for _, dir := range dirs {
+		block, err := promtsdb.OpenBlock(logger, dir, nil)
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		querier, err := promtsdb.NewBlockQuerier(block, block.MinTime(), block.MaxTime())
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		seriesSet := querier.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".*"))
+		for seriesSet.Next() {
+			results = append(results, seriesSet.At().Labels().String())
+		}
+		if err := seriesSet.Err(); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		querier.Close()
+		block.Close()
+	}
+
+	sort.Strings(results)
+	return results
+}
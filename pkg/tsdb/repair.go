@@ -0,0 +1,126 @@
+package tsdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// RepairDrop removes the out-of-sequence head chunk files. The head data
+	// stored in these files is lost.
+	RepairDrop = "drop"
+
+	// RepairRenumber renames the head chunk files so that their sequence
+	// numbers are contiguous, keeping all the head data.
+	RepairRenumber = "renumber"
+
+	// RepairNone leaves the head chunk files untouched.
+	RepairNone = "none"
+)
+
+var errUnsupportedRepairStrategy = fmt.Errorf("unsupported repair strategy")
+
+// ValidateRepairStrategy returns an error if strategy isn't a supported head
+// chunks repair strategy.
+func ValidateRepairStrategy(strategy string) error {
+	switch strategy {
+	case RepairDrop, RepairRenumber, RepairNone:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedRepairStrategy, strategy)
+	}
+}
+
+// HeadChunksRepair describes how the head chunk files are repaired.
+type HeadChunksRepair struct {
+	// Dropped contains the out-of-sequence files that are removed.
+	Dropped []HeadChunkFile
+
+	// Renamed maps the paths of the renumbered files to their new names.
+	Renamed map[string]string
+}
+
+// LostBytes returns the total size of the dropped files.
+func (r *HeadChunksRepair) LostBytes() int64 {
+	var size int64
+	for _, file := range r.Dropped {
+		size += file.Size
+	}
+	return size
+}
+
+// PlanHeadChunksRepair determines how the head chunk files are to be repaired,
+// according to strategy. It doesn't modify the data directory.
+func (t *Tsdb) PlanHeadChunksRepair(strategy string) (*HeadChunksRepair, error) {
+	if err := ValidateRepairStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	files, outOfSequence, err := t.HeadChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	repair := &HeadChunksRepair{Renamed: map[string]string{}}
+	if len(outOfSequence) == 0 {
+		return repair, nil
+	}
+
+	switch strategy {
+	case RepairDrop:
+		repair.Dropped = outOfSequence
+	case RepairRenumber:
+		// pack the sequence numbers against the latest file, so that
+		// Prometheus can continue writing new files after it
+		last := files[len(files)-1].Seq
+		for i, file := range files {
+			seq := last - (len(files) - 1 - i)
+			if seq != file.Seq {
+				repair.Renamed[file.Path] = fmt.Sprintf("%06d", seq)
+			}
+		}
+	}
+
+	_ = level.Debug(t.logger).Log("message", "planned head chunks repair",
+		"strategy", strategy,
+		"numDropped", len(repair.Dropped),
+		"numRenamed", len(repair.Renamed))
+	return repair, nil
+}
+
+// RepairHeadChunks applies the repair strategy to the head chunk files in the
+// data directory. It is intended to be used on a local copy of a data dump.
+func (t *Tsdb) RepairHeadChunks(strategy string) (*HeadChunksRepair, error) {
+	repair, err := t.PlanHeadChunksRepair(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range repair.Dropped {
+		if err := os.Remove(file.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	// rename from the latest file backwards, so that the new names never
+	// collide with the existing ones
+	files, _, err := t.HeadChunks()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		name, ok := repair.Renamed[files[i].Path]
+		if !ok {
+			continue
+		}
+
+		if err := os.Rename(files[i].Path, filepath.Join(filepath.Dir(files[i].Path), name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return repair, nil
+}
@@ -0,0 +1,77 @@
+package tsdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ihcsim/promdump/pkg/log"
+)
+
+func TestRepairHeadChunks(t *testing.T) {
+	var testCases = []struct {
+		strategy   string
+		expected   []int
+		numDropped int
+		lostBytes  int64
+	}{
+		{strategy: RepairDrop, expected: []int{33, 34}, numDropped: 2, lostBytes: 16},
+		{strategy: RepairRenumber, expected: []int{31, 32, 33, 34}},
+		{strategy: RepairNone, expected: []int{27, 29, 33, 34}},
+	}
+
+	logger := log.New("debug", io.Discard)
+	for _, tc := range testCases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "promdump-repair-test")
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			chunksDir := filepath.Join(tempDir, "chunks_head")
+			if err := os.MkdirAll(chunksDir, 0755); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			for _, file := range []string{"000027", "000029", "000033", "000034"} {
+				if err := os.WriteFile(filepath.Join(chunksDir, file), []byte("00000000"), 0600); err != nil {
+					t.Fatal("unexpected error: ", err)
+				}
+			}
+
+			tsdb, err := New(tempDir, logger)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			defer tsdb.Close()
+
+			repair, err := tsdb.RepairHeadChunks(tc.strategy)
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual := len(repair.Dropped); actual != tc.numDropped {
+				t.Errorf("mismatch number of dropped files. expected: %d, actual: %d", tc.numDropped, actual)
+			}
+
+			if actual := repair.LostBytes(); actual != tc.lostBytes {
+				t.Errorf("mismatch lost bytes. expected: %d, actual: %d", tc.lostBytes, actual)
+			}
+
+			files, outOfSequence, err := tsdb.HeadChunks()
+			if err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+
+			if actual := seqs(files); !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("mismatch head chunk files. expected: %v, actual: %v", tc.expected, actual)
+			}
+
+			if tc.strategy != RepairNone && len(outOfSequence) > 0 {
+				t.Errorf("unexpected out-of-sequence files: %v", outOfSequence)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// commitInterval is the number of series appended to the in-memory head
+// before the pending samples are committed.
+const commitInterval = 1000
+
+// labelsFunc maps the label set of a series to a new label set. A nil return
+// value drops the series.
+type labelsFunc func(labels.Labels) labels.Labels
+
+// appendFunc appends the samples read from it to app, as the series lset.
+type appendFunc func(app storage.Appender, lset labels.Labels, it chunkenc.Iterator) error
+
+// FlushHead persists the data in the WAL and head chunks into a new persistent
+// block, and removes the 'wal' and 'chunks_head' directories from the data
+// directory. It is intended to be used on a local copy of a data dump, before
+// its blocks are rewritten.
+func (t *Tsdb) FlushHead() error {
+	walDir := filepath.Join(t.dataDir, "wal")
+	if _, err := os.Stat(walDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	_ = level.Debug(t.logger).Log("message", "flushing head block", "datadir", t.dataDir)
+	if err := t.db.FlushWAL(t.dataDir); err != nil {
+		return fmt.Errorf("failed to flush head block: %w", err)
+	}
+
+	for _, dir := range []string{walDir, filepath.Join(t.dataDir, "chunks_head")} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewrite replaces every persistent block in the data directory with a new
+// block whose series labels are mapped by mapLabels, and whose samples are
+// appended by appendSamples. Series that are mapped to the same label set are
+// merged.
+func (t *Tsdb) rewrite(mapLabels labelsFunc, appendSamples appendFunc) error {
+	dirs, err := blockDirs(t.dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := t.rewriteBlock(dir, mapLabels, appendSamples); err != nil {
+			return fmt.Errorf("failed to rewrite block %s: %w", filepath.Base(dir), err)
+		}
+	}
+
+	return nil
+}
+
+// rewriteBlock writes a new block with the rewritten content of the block at
+// dir, and then removes the original block.
+func (t *Tsdb) rewriteBlock(dir string, mapLabels labelsFunc, appendSamples appendFunc) error {
+	uid, numSeries, err := t.writeBlock(dir, mapLabels, appendSamples)
+	if err != nil {
+		return err
+	}
+
+	_ = level.Debug(t.logger).Log("message", "finished rewriting block",
+		"path", filepath.Base(dir),
+		"newPath", uid.String(),
+		"numSeries", numSeries)
+	return os.RemoveAll(dir)
+}
+
+func (t *Tsdb) writeBlock(dir string, mapLabels labelsFunc, appendSamples appendFunc) (ulid.ULID, int, error) {
+	block, err := tsdb.OpenBlock(t.logger, dir, nil)
+	if err != nil {
+		return ulid.ULID{}, 0, err
+	}
+	defer block.Close()
+
+	var (
+		meta = block.Meta()
+		// the chunk range is doubled so that the head appender accepts all
+		// the samples within [meta.MinTime, meta.MaxTime]
+		chunkRange = 2 * (meta.MaxTime - meta.MinTime + 1)
+	)
+	_ = level.Debug(t.logger).Log("message", "rewriting block",
+		"path", filepath.Base(dir),
+		"numSeries", meta.Stats.NumSeries)
+
+	querier, err := tsdb.NewBlockQuerier(block, meta.MinTime, meta.MaxTime)
+	if err != nil {
+		return ulid.ULID{}, 0, err
+	}
+	defer querier.Close()
+
+	// group the series by their new label sets
+	var (
+		groups = map[string][]storage.Series{}
+		lsets  = []labels.Labels{}
+	)
+	all := labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".*")
+	seriesSet := querier.Select(false, nil, all)
+	for seriesSet.Next() {
+		series := seriesSet.At()
+		lset := mapLabels(series.Labels())
+		if lset == nil {
+			continue
+		}
+
+		key := lset.String()
+		if _, exists := groups[key]; !exists {
+			lsets = append(lsets, lset)
+		}
+		groups[key] = append(groups[key], series)
+	}
+	if err := seriesSet.Err(); err != nil {
+		return ulid.ULID{}, 0, err
+	}
+	sort.Slice(lsets, func(i, j int) bool {
+		return labels.Compare(lsets[i], lsets[j]) < 0
+	})
+
+	chunkDir, err := os.MkdirTemp("", "promdump-chunks")
+	if err != nil {
+		return ulid.ULID{}, 0, err
+	}
+	defer os.RemoveAll(chunkDir)
+
+	head, err := tsdb.NewHead(nil, t.logger, nil, chunkRange, chunkDir, nil, tsdb.DefaultStripeSize, nil)
+	if err != nil {
+		return ulid.ULID{}, 0, err
+	}
+	defer head.Close()
+
+	app := head.Appender(context.Background())
+	for i, lset := range lsets {
+		series := storage.ChainedSeriesMerge(groups[lset.String()]...)
+		if err := appendSamples(app, lset, series.Iterator()); err != nil {
+			_ = app.Rollback()
+			return ulid.ULID{}, 0, err
+		}
+
+		if (i+1)%commitInterval == 0 {
+			if err := app.Commit(); err != nil {
+				return ulid.ULID{}, 0, err
+			}
+			app = head.Appender(context.Background())
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return ulid.ULID{}, 0, err
+	}
+
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, t.logger,
+		tsdb.ExponentialBlockRanges(tsdb.DefaultBlockDuration, 3, 5), nil)
+	if err != nil {
+		return ulid.ULID{}, 0, err
+	}
+
+	uid, err := compactor.Write(t.dataDir, head, meta.MinTime, meta.MaxTime, &meta)
+	return uid, len(lsets), err
+}
+
+// appendAll appends all the samples read from it to app.
+func appendAll(app storage.Appender, lset labels.Labels, it chunkenc.Iterator) error {
+	var ref uint64
+	for it.Next() {
+		ts, v := it.At()
+		if ref != 0 {
+			if err := app.AddFast(ref, ts, v); err == nil {
+				continue
+			}
+		}
+
+		var err error
+		if ref, err = app.Add(lset, ts, v); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
+
+// blockDirs returns the paths of all the persistent block directories found
+// in dataDir.
+func blockDirs(dataDir string) ([]string, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if _, err := ulid.ParseStrict(entry.Name()); err != nil {
+			continue
+		}
+
+		dirs = append(dirs, filepath.Join(dataDir, entry.Name()))
+	}
+
+	return dirs, nil
+}
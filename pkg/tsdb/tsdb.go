@@ -1,7 +1,10 @@
 package tsdb
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -9,7 +12,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/ihcsim/promdump/pkg/log"
 	"github.com/prometheus/prometheus/tsdb"
-	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/prometheus/prometheus/tsdb/wal"
 )
 
@@ -41,6 +44,37 @@ type Meta struct {
 	Size       int64
 }
 
+// Metadata contains the metadata of both the head block and the persistent
+// blocks.
+type Metadata struct {
+	Head   *HeadMeta  `json:"head"`
+	Blocks *BlockMeta `json:"blocks"`
+}
+
+// TimeRange returns the time range covered by the head block and the
+// persistent blocks. Both minTime and maxTime are zero if there are no data.
+func (m *Metadata) TimeRange() (minTime, maxTime time.Time) {
+	var metas []*Meta
+	if m.Head != nil && m.Head.Meta != nil && m.Head.NumSeries > 0 {
+		metas = append(metas, m.Head.Meta)
+	}
+	if m.Blocks != nil && m.Blocks.Meta != nil && m.Blocks.BlockCount > 0 {
+		metas = append(metas, m.Blocks.Meta)
+	}
+
+	for _, meta := range metas {
+		if minTime.IsZero() || meta.MinTime.Before(minTime) {
+			minTime = meta.MinTime
+		}
+
+		if maxTime.IsZero() || meta.MaxTime.After(maxTime) {
+			maxTime = meta.MaxTime
+		}
+	}
+
+	return minTime, maxTime
+}
+
 // New returns a new instance of Tsdb.
 func New(dataDir string, logger *log.Logger) (*Tsdb, error) {
 	db, err := tsdb.OpenDBReadOnly(dataDir, logger)
@@ -135,49 +169,115 @@ func (t *Tsdb) Meta() (*HeadMeta, *BlockMeta, error) {
 	return headMeta, blockMeta, nil
 }
 
-// headMeta() is based on the implementation of tsdb.FlushWAL() at
-// https://github.com/prometheus/prometheus/blob/80545bfb2eb8f9deeedc442130f7c4dc34525d8d/tsdb/db.go#L334
+// headMeta reads the head block metadata from the WAL. Unlike tsdb.NewHead(),
+// the WAL is only read; no new segment is created in the data directory, so
+// that promdump doesn't leave files behind, e.g. owned by a different user
+// when it runs in an ephemeral container. Samples older than the max time of
+// the last persistent block are ignored, as they are by Head.Init().
 func (t *Tsdb) headMeta() (*HeadMeta, error) {
 	dir := filepath.Join(t.dataDir, "wal")
 	_ = level.Debug(t.logger).Log("message", "retrieving head block metadata", "datadir", dir)
-	wal, err := wal.Open(t.logger, dir)
+
+	minValidTime, err := t.headMinValidTime()
 	if err != nil {
 		return nil, err
 	}
-	defer wal.Close()
 
-	head, err := tsdb.NewHead(nil, t.logger, wal,
-		tsdb.DefaultBlockDuration,
-		"",
-		chunkenc.NewPool(),
-		tsdb.DefaultStripeSize,
-		nil)
-	if err != nil {
+	var (
+		decoder    record.Decoder
+		series     = map[uint64]struct{}{}
+		numSamples uint64
+		minTime    = int64(math.MaxInt64)
+		maxTime    = int64(math.MinInt64)
+	)
+	readRecord := func(rec []byte) error {
+		switch decoder.Type(rec) {
+		case record.Series:
+			refs, err := decoder.Series(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				series[ref.Ref] = struct{}{}
+			}
+
+		case record.Samples:
+			samples, err := decoder.Samples(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, sample := range samples {
+				if sample.T < minValidTime {
+					continue
+				}
+				numSamples++
+				if sample.T < minTime {
+					minTime = sample.T
+				}
+				if sample.T > maxTime {
+					maxTime = sample.T
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := t.readHead(readRecord); err != nil {
 		return nil, err
 	}
 
+	headMeta := &HeadMeta{Meta: &Meta{}}
+	headMeta.NumSeries = uint64(len(series))
+	headMeta.NumSamples = numSamples
+	if minTime <= maxTime {
+		headMeta.MinTime = time.Unix(0, nanoseconds(minTime)).UTC()
+		headMeta.MaxTime = time.Unix(0, nanoseconds(maxTime)).UTC()
+	}
+
+	// NumChunks is not populated by default. See
+	// https://github.com/prometheus/prometheus/blob/80545bfb2eb8f9deeedc442130f7c4dc34525d8d/tsdb/head.go#L1600
+	return headMeta, nil
+}
+
+// headMinValidTime returns the min time of the samples of the head block,
+// i.e. the max time of the last persistent block.
+func (t *Tsdb) headMinValidTime() (int64, error) {
 	blocks, err := t.db.Blocks()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	minValidTime := int64(math.MinInt64)
-	if len(blocks) > 0 {
-		minValidTime = blocks[len(blocks)-1].Meta().MaxTime
+	if len(blocks) == 0 {
+		return math.MinInt64, nil
 	}
-	if err := head.Init(minValidTime); err != nil {
-		return nil, err
+	return blocks[len(blocks)-1].Meta().MaxTime, nil
+}
+
+// readHead calls fn with every record of the last WAL checkpoint and of the
+// WAL segments that follow it, without modifying the WAL.
+func (t *Tsdb) readHead(fn func(rec []byte) error) error {
+	dir := filepath.Join(t.dataDir, "wal")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
 	}
 
-	// NumSamples and NumChunks are not populated by default. See
-	// https://github.com/prometheus/prometheus/blob/80545bfb2eb8f9deeedc442130f7c4dc34525d8d/tsdb/head.go#L1600
-	return &HeadMeta{
-		Meta: &Meta{
-			MaxTime:   time.Unix(0, nanoseconds(head.MaxTime())).UTC(),
-			MinTime:   time.Unix(0, nanoseconds(head.MinTime())).UTC(),
-			NumSeries: head.Meta().Stats.NumSeries,
-		},
-	}, nil
+	from := -1
+	checkpoint, checkpointIndex, err := wal.LastCheckpoint(dir)
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		return err
+	}
+	if checkpoint != "" {
+		if _, err := t.readWAL(checkpoint, -1, false, fn); err != nil {
+			return fmt.Errorf("can't read checkpoint %s: %w", filepath.Base(checkpoint), err)
+		}
+		from = checkpointIndex + 1
+	}
+
+	if _, err := t.readWAL(dir, from, true, fn); err != nil {
+		return fmt.Errorf("can't read WAL: %w", err)
+	}
+
+	return nil
 }
 
 func (t *Tsdb) blockMeta() (*BlockMeta, error) {
@@ -244,3 +344,7 @@ func (t *Tsdb) blockMeta() (*BlockMeta, error) {
 func nanoseconds(milliseconds int64) int64 {
 	return milliseconds * 1000000
 }
+
+func milliseconds(nanoseconds int64) int64 {
+	return nanoseconds / 1000000
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/ihcsim/promdump/pkg/log"
 	"github.com/prometheus/prometheus/pkg/labels"
 	promtsdb "github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
 )
 
 var series []*promtsdb.MetricSample
@@ -307,3 +309,70 @@ func unix(date string, d time.Duration, t *testing.T) int64 {
 
 	return 0
 }
+
+func TestHeadMeta(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "promdump-tsdb-test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	walDir := filepath.Join(tempDir, "wal")
+	w, err := wal.New(nil, nil, walDir, false)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	var encoder record.Encoder
+	records := [][]byte{
+		encoder.Series([]record.RefSeries{
+			{Ref: 1, Labels: labels.FromStrings("__name__", "up", "job", "prometheus")},
+			{Ref: 2, Labels: labels.FromStrings("__name__", "up", "job", "node")},
+		}, nil),
+		encoder.Samples([]record.RefSample{
+			{Ref: 1, T: 1618750805939, V: 1},
+			{Ref: 2, T: 1618770501050, V: 1},
+		}, nil),
+	}
+	if err := w.Log(records...); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	logger := log.New("debug", io.Discard)
+	tsdb, err := New(tempDir, logger)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer tsdb.Close()
+
+	headMeta, err := tsdb.headMeta()
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	if expected := uint64(2); headMeta.NumSeries != expected {
+		t.Errorf("mismatch total series. expected: %d, actual: %d", expected, headMeta.NumSeries)
+	}
+
+	if expected := uint64(2); headMeta.NumSamples != expected {
+		t.Errorf("mismatch total samples. expected: %d, actual: %d", expected, headMeta.NumSamples)
+	}
+
+	if expected := time.Unix(0, 1618750805939*int64(time.Millisecond)).UTC(); !headMeta.MinTime.Equal(expected) {
+		t.Errorf("mismatch min time. expected: %s, actual: %s", expected, headMeta.MinTime)
+	}
+
+	if expected := time.Unix(0, 1618770501050*int64(time.Millisecond)).UTC(); !headMeta.MaxTime.Equal(expected) {
+		t.Errorf("mismatch max time. expected: %s, actual: %s", expected, headMeta.MaxTime)
+	}
+
+	// reading the metadata must not create new WAL segments
+	if _, last, err := wal.Segments(walDir); err != nil {
+		t.Fatal("unexpected error: ", err)
+	} else if last != 0 {
+		t.Errorf("unexpected WAL segments. expected last segment: 0, actual: %d", last)
+	}
+}